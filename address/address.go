@@ -0,0 +1,132 @@
+package address
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// Networks are the networks we can encode addresses for, in the order we try
+// them when decoding.
+var Networks = []*chaincfg.Params{
+	&chaincfg.MainNetParams,
+	&chaincfg.TestNet3Params,
+	&chaincfg.SigNetParams,
+	&chaincfg.RegressionNetParams,
+}
+
+// ParseNetwork returns the chain parameters for the given network name. The
+// empty string is parsed as mainnet.
+func ParseNetwork(name string) (*chaincfg.Params, error) {
+	switch name {
+	case "", "mainnet":
+		return &chaincfg.MainNetParams, nil
+	case "testnet":
+		return &chaincfg.TestNet3Params, nil
+	case "signet":
+		return &chaincfg.SigNetParams, nil
+	case "regtest":
+		return &chaincfg.RegressionNetParams, nil
+	default:
+		return nil, fmt.Errorf("unknown network %q", name)
+	}
+}
+
+// Taproot returns the bech32m encoded address for the given taproot output
+// key.
+func Taproot(outputKey *btcec.PublicKey, net *chaincfg.Params) (string,
+	error) {
+
+	addr, err := btcutil.NewAddressTaproot(
+		schnorr.SerializePubKey(outputKey), net,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return addr.EncodeAddress(), nil
+}
+
+// FromPkScript returns the address for the given output script, or the empty
+// string if the script doesn't have a standard address encoding.
+func FromPkScript(pkScript []byte, net *chaincfg.Params) string {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, net)
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+
+	return addrs[0].EncodeAddress()
+}
+
+// Info describes a decoded address.
+type Info struct {
+	// Network is the name of the network the address is for. Testnet and
+	// signet addresses are encoded the same way, so signet addresses are
+	// reported as testnet.
+	Network string
+
+	// Type is the type of output script the address encodes.
+	Type string
+
+	// WitnessVersion and WitnessProgram are set for segwit addresses.
+	WitnessVersion byte
+	WitnessProgram []byte
+	IsWitness      bool
+
+	// PkScript is the output script paying to the address.
+	PkScript []byte
+}
+
+// Decode decodes the address, trying all known networks.
+func Decode(addr string) (*Info, error) {
+	for _, net := range Networks {
+		a, err := btcutil.DecodeAddress(addr, net)
+		if err != nil || !a.IsForNet(net) {
+			continue
+		}
+
+		pkScript, err := txscript.PayToAddrScript(a)
+		if err != nil {
+			return nil, err
+		}
+
+		info := &Info{
+			Network:  networkName(net),
+			Type:     txscript.GetScriptClass(pkScript).String(),
+			PkScript: pkScript,
+		}
+
+		version, program, err := txscript.ExtractWitnessProgramInfo(
+			pkScript,
+		)
+		if err == nil {
+			info.IsWitness = true
+			info.WitnessVersion = byte(version)
+			info.WitnessProgram = program
+		}
+
+		return info, nil
+	}
+
+	return nil, fmt.Errorf("unable to decode address %s", addr)
+}
+
+// networkName returns the name of the network as accepted by ParseNetwork.
+func networkName(net *chaincfg.Params) string {
+	switch net.Net {
+	case chaincfg.MainNetParams.Net:
+		return "mainnet"
+	case chaincfg.TestNet3Params.Net:
+		return "testnet"
+	case chaincfg.SigNetParams.Net:
+		return "signet"
+	case chaincfg.RegressionNetParams.Net:
+		return "regtest"
+	default:
+		return net.Name
+	}
+}
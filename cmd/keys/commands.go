@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/halseth/tapsim/keyring"
+)
+
+// keyOptions select the key a command operates on, either a private key or a
+// public key.
+type keyOptions struct {
+	Key    string `short:"k" long:"key" description:"hex private key, or name of a key in the seed or keyring"`
+	PubKey string `long:"pubkey" description:"hex public key, either 32 byte x-only or 33 byte compressed, instead of key"`
+}
+
+// parse returns the selected private key, nil if a public key was given, and
+// the public key.
+func (o *keyOptions) parse() (*btcec.PrivateKey, *btcec.PublicKey, error) {
+	switch {
+	case o.Key != "" && o.PubKey != "":
+		return nil, nil, fmt.Errorf("cannot set both key and pubkey")
+
+	case o.Key != "":
+		privKey, err := parsePrivKey(o.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return privKey, privKey.PubKey(), nil
+
+	case o.PubKey != "":
+		pubKey, err := parsePubKey(o.PubKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, pubKey, nil
+	}
+
+	return nil, nil, fmt.Errorf("must set key or pubkey")
+}
+
+// parsePrivKey parses the hex encoded private key. If a seed or keyring is
+// given, the key can also be the name of a key.
+func parsePrivKey(s string) (*btcec.PrivateKey, error) {
+	kr, err := keyring.Open(cfg.Keyring, cfg.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes, err := hex.DecodeString(s)
+	if err != nil || len(keyBytes) != btcec.PrivKeyBytesLen {
+		if kr != nil {
+			return kr.Key(s)
+		}
+
+		return nil, fmt.Errorf("private key must be %d hex bytes",
+			btcec.PrivKeyBytesLen)
+	}
+
+	var k btcec.ModNScalar
+	if overflow := k.SetByteSlice(keyBytes); overflow || k.IsZero() {
+		return nil, fmt.Errorf("private key out of range")
+	}
+
+	return btcec.PrivKeyFromScalar(&k), nil
+}
+
+// parsePubKey parses the hex encoded public key, either x-only or compressed.
+func parsePubKey(s string) (*btcec.PublicKey, error) {
+	keyBytes, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keyBytes) == schnorr.PubKeyBytesLen {
+		return schnorr.ParsePubKey(keyBytes)
+	}
+
+	return btcec.ParsePubKey(keyBytes)
+}
+
+// parseHash parses the 32 byte hex encoded hash.
+func parseHash(s string) ([]byte, error) {
+	h, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(h) != chainhash.HashSize {
+		return nil, fmt.Errorf("must be %d bytes", chainhash.HashSize)
+	}
+
+	return h, nil
+}
+
+// parity returns "odd" or "even" for the y coordinate of the key.
+func parity(pubKey *btcec.PublicKey) string {
+	if pubKey.Y().Bit(0) == 1 {
+		return "odd"
+	}
+
+	return "even"
+}
+
+// printKeys prints the private key if known, and the public key.
+func printKeys(privKey *btcec.PrivateKey, pubKey *btcec.PublicKey) {
+	if privKey != nil {
+		fmt.Printf("privkey: %x\n", privKey.Serialize())
+	}
+	fmt.Printf("pubkey: %x\n", pubKey.SerializeCompressed())
+	fmt.Printf("xonly: %x\n", schnorr.SerializePubKey(pubKey))
+	fmt.Printf("parity: %s\n", parity(pubKey))
+}
+
+// xonlyCommand prints the public keys of a private key.
+type xonlyCommand struct {
+	Key string `short:"k" long:"key" description:"hex private key, or name of a key in the seed or keyring" required:"true"`
+}
+
+// Execute prints the public keys.
+func (c *xonlyCommand) Execute(_ []string) error {
+	privKey, err := parsePrivKey(c.Key)
+	if err != nil {
+		return err
+	}
+
+	printKeys(privKey, privKey.PubKey())
+	return nil
+}
+
+// parityCommand prints the parity of a key.
+type parityCommand struct {
+	keyOptions
+}
+
+// Execute prints the parity.
+func (c *parityCommand) Execute(_ []string) error {
+	_, pubKey, err := c.parse()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(parity(pubKey))
+	return nil
+}
+
+// negateCommand negates a key.
+type negateCommand struct {
+	keyOptions
+}
+
+// Execute negates the key and prints the result.
+func (c *negateCommand) Execute(_ []string) error {
+	privKey, pubKey, err := c.parse()
+	if err != nil {
+		return err
+	}
+
+	if privKey != nil {
+		k := privKey.Key
+		k.Negate()
+		privKey = btcec.PrivKeyFromScalar(&k)
+		printKeys(privKey, privKey.PubKey())
+		return nil
+	}
+
+	var p btcec.JacobianPoint
+	pubKey.AsJacobian(&p)
+	p.Y.Negate(1).Normalize()
+
+	printKeys(nil, btcec.NewPublicKey(&p.X, &p.Y))
+	return nil
+}
+
+// tweakCommand adds a tweak to a key.
+type tweakCommand struct {
+	keyOptions
+	Tweak string `short:"t" long:"tweak" description:"32 byte hex tweak to add" required:"true"`
+	XOnly bool   `long:"xonly" description:"negate the key first if its y coordinate is odd, like BIP341 taproot tweaks"`
+}
+
+// Execute tweaks the key and prints the result.
+func (c *tweakCommand) Execute(_ []string) error {
+	privKey, pubKey, err := c.parse()
+	if err != nil {
+		return err
+	}
+
+	tweakBytes, err := parseHash(c.Tweak)
+	if err != nil {
+		return fmt.Errorf("tweak %w", err)
+	}
+
+	var t btcec.ModNScalar
+	if overflow := t.SetByteSlice(tweakBytes); overflow {
+		return fmt.Errorf("tweak out of range")
+	}
+
+	negate := c.XOnly && parity(pubKey) == "odd"
+
+	if privKey != nil {
+		k := privKey.Key
+		if negate {
+			k.Negate()
+		}
+		k.Add(&t)
+		if k.IsZero() {
+			return fmt.Errorf("tweaked key is zero")
+		}
+
+		privKey = btcec.PrivKeyFromScalar(&k)
+		printKeys(privKey, privKey.PubKey())
+		return nil
+	}
+
+	var p, tG, r btcec.JacobianPoint
+	pubKey.AsJacobian(&p)
+	if negate {
+		p.Y.Negate(1).Normalize()
+	}
+	btcec.ScalarBaseMultNonConst(&t, &tG)
+	btcec.AddNonConst(&p, &tG, &r)
+	if (r.X.IsZero() && r.Y.IsZero()) || r.Z.IsZero() {
+		return fmt.Errorf("tweaked key is infinity")
+	}
+	r.ToAffine()
+
+	printKeys(nil, btcec.NewPublicKey(&r.X, &r.Y))
+	return nil
+}
+
+// messageOptions select the message to sign or verify.
+type messageOptions struct {
+	Msg string `short:"m" long:"msg" description:"hex message, 32 bytes unless tag is set" required:"true"`
+	Tag string `long:"tag" description:"sign the tagged hash of the message with the given tag, instead of the message itself"`
+}
+
+// hash returns the 32 byte hash to sign.
+func (o *messageOptions) hash() ([]byte, error) {
+	if o.Tag == "" {
+		h, err := parseHash(o.Msg)
+		if err != nil {
+			return nil, fmt.Errorf("message %w, or set tag", err)
+		}
+
+		return h, nil
+	}
+
+	msg, err := hex.DecodeString(o.Msg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := chainhash.TaggedHash([]byte(o.Tag), msg)
+	fmt.Printf("hash: %x\n", h[:])
+	return h[:], nil
+}
+
+// signCommand creates a BIP340 signature.
+type signCommand struct {
+	messageOptions
+	Key string `short:"k" long:"key" description:"hex private key, or name of a key in the seed or keyring" required:"true"`
+	Aux string `long:"aux" description:"32 byte hex auxiliary randomness as in BIP340, instead of deterministic RFC6979 nonces"`
+}
+
+// Execute signs the message and prints the signature.
+func (c *signCommand) Execute(_ []string) error {
+	privKey, err := parsePrivKey(c.Key)
+	if err != nil {
+		return err
+	}
+
+	hash, err := c.hash()
+	if err != nil {
+		return err
+	}
+
+	var opts []schnorr.SignOption
+	if c.Aux != "" {
+		aux, err := parseHash(c.Aux)
+		if err != nil {
+			return fmt.Errorf("aux %w", err)
+		}
+
+		var auxData [32]byte
+		copy(auxData[:], aux)
+		opts = append(opts, schnorr.CustomNonce(auxData))
+	}
+
+	sig, err := schnorr.Sign(privKey, hash, opts...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("pubkey: %x\n", schnorr.SerializePubKey(privKey.PubKey()))
+	fmt.Printf("signature: %x\n", sig.Serialize())
+	return nil
+}
+
+// verifyCommand verifies a BIP340 signature.
+type verifyCommand struct {
+	messageOptions
+	keyOptions
+	Sig string `short:"s" long:"sig" description:"64 byte hex signature" required:"true"`
+}
+
+// Execute verifies the signature.
+func (c *verifyCommand) Execute(_ []string) error {
+	_, pubKey, err := c.parse()
+	if err != nil {
+		return err
+	}
+
+	hash, err := c.hash()
+	if err != nil {
+		return err
+	}
+
+	sigBytes, err := hex.DecodeString(c.Sig)
+	if err != nil {
+		return err
+	}
+
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return err
+	}
+
+	if !sig.Verify(hash, pubKey) {
+		return fmt.Errorf("signature invalid for key %x",
+			schnorr.SerializePubKey(pubKey))
+	}
+
+	fmt.Println("signature valid")
+	return nil
+}
+
+// taggedHashCommand computes a BIP340 tagged hash.
+type taggedHashCommand struct {
+	Tag  string   `long:"tag" description:"tag, e.g. TapLeaf" required:"true"`
+	Data []string `short:"d" long:"data" description:"hex data to hash, can be repeated to concatenate"`
+}
+
+// Execute prints the tagged hash.
+func (c *taggedHashCommand) Execute(_ []string) error {
+	var msgs [][]byte
+	for _, d := range c.Data {
+		msg, err := hex.DecodeString(d)
+		if err != nil {
+			return err
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	h := chainhash.TaggedHash([]byte(c.Tag), msgs...)
+	fmt.Printf("%x\n", h[:])
+	return nil
+}
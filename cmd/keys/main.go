@@ -2,36 +2,123 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/halseth/tapsim/keyring"
 	flags "github.com/jessevdk/go-flags"
 )
 
-const usage = "Returns n keys on the format 'privkey,pubkey'"
+const usage = "Returns n keys on the format 'privkey,pubkey', or runs one of the key utilities"
 
 type config struct {
-	Num int `short:"n" long:"num" description:"number of keys to generate"`
+	Num     int      `short:"n" long:"num" description:"number of keys to generate"`
+	Seed    string   `long:"seed" description:"hex seed to derive the keys from, named key1 to keyN, instead of generating random keys"`
+	Keyring string   `long:"keyring" description:"keyring file to derive the keys from, instead of seed"`
+	Names   []string `long:"name" description:"name of a key to derive from the seed or keyring, can be repeated"`
 }
 
 var cfg = config{}
 
+// commands are the key utilities, added as subcommands.
+var commands = []struct {
+	name    string
+	short   string
+	long    string
+	command interface{}
+}{
+	{
+		"xonly", "print the public keys of a private key",
+		"Print the compressed and x-only public key of the private " +
+			"key, and the parity of its y coordinate.",
+		&xonlyCommand{},
+	},
+	{
+		"parity", "print the parity of a key",
+		"Print whether the y coordinate of the public key is even or " +
+			"odd. BIP340 implicitly uses the key with even y for an " +
+			"x-only key.",
+		&parityCommand{},
+	},
+	{
+		"negate", "negate a key",
+		"Negate the private or public key. The negated public key has " +
+			"the same x-only key, but opposite parity.",
+		&negateCommand{},
+	},
+	{
+		"tweak", "tweak a key",
+		"Add the tweak to the private key, or the tweak times the " +
+			"generator to the public key.",
+		&tweakCommand{},
+	},
+	{
+		"sign", "create a BIP340 signature",
+		"Sign the 32 byte message, or its tagged hash if a tag is " +
+			"given, using BIP340 schnorr signatures.",
+		&signCommand{},
+	},
+	{
+		"verify", "verify a BIP340 signature",
+		"Verify the BIP340 schnorr signature over the 32 byte message, " +
+			"or its tagged hash if a tag is given.",
+		&verifyCommand{},
+	},
+	{
+		"taggedhash", "compute a BIP340 tagged hash",
+		"Compute sha256(sha256(tag) || sha256(tag) || data), where " +
+			"data is the concatenation of the given data.",
+		&taggedHashCommand{},
+	},
+}
+
 func main() {
 	parser := flags.NewParser(&cfg, flags.Default)
 	parser.Usage = usage
+	parser.SubcommandsOptional = true
+
+	for _, c := range commands {
+		_, err := parser.AddCommand(c.name, c.short, c.long, c.command)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
 	if _, err := parser.Parse(); err != nil {
-		fmt.Println(err)
+		// The parser has already printed the error to stderr. Asking
+		// for help is not a failure.
+		if flagsErr, ok := err.(*flags.Error); ok &&
+			flagsErr.Type == flags.ErrHelp {
+
+			return
+		}
+		os.Exit(1)
+	}
+
+	// Subcommands are run by the parser.
+	if parser.Active != nil {
 		return
 	}
 
 	err := run()
 	if err != nil {
-		fmt.Println(err)
-		return
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
 func run() error {
+	kr, err := keyring.Open(cfg.Keyring, cfg.Seed)
+	if err != nil {
+		return err
+	}
+
+	if kr != nil {
+		return derive(kr)
+	}
+
 	if cfg.Num < 1 {
 		return fmt.Errorf("number of keys mus be positive")
 	}
@@ -41,10 +128,40 @@ func run() error {
 		if err != nil {
 			return err
 		}
-		privKeyBytes := privKey.Serialize()
-		pubKey := privKey.PubKey()
-		pubKeyBytes := schnorr.SerializePubKey(pubKey)
-		fmt.Printf("%x,%x\n", privKeyBytes, pubKeyBytes)
+		printKey(privKey)
 	}
 	return nil
 }
+
+// derive prints the keys from the keyring. Without names or a number of keys
+// given, the keys in the keyring file are printed.
+func derive(kr *keyring.Keyring) error {
+	names := cfg.Names
+	for i := 0; i < cfg.Num; i++ {
+		names = append(names, fmt.Sprintf("key%d", i+1))
+	}
+
+	if len(names) == 0 {
+		names = kr.Names()
+	}
+
+	if len(names) == 0 {
+		return fmt.Errorf("must set number of keys or names to derive")
+	}
+
+	for _, name := range names {
+		privKey, err := kr.Key(name)
+		if err != nil {
+			return err
+		}
+		printKey(privKey)
+	}
+	return nil
+}
+
+func printKey(privKey *btcec.PrivateKey) {
+	privKeyBytes := privKey.Serialize()
+	pubKey := privKey.PubKey()
+	pubKeyBytes := schnorr.SerializePubKey(pubKey)
+	fmt.Printf("%x,%x\n", privKeyBytes, pubKeyBytes)
+}
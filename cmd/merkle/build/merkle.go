@@ -6,87 +6,325 @@ import (
 	"encoding/hex"
 	"fmt"
 	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
-func Merkle(leaves []string) ([]string, error) {
+// Padding is the strategy used to pad levels with an odd number of nodes.
+type Padding int
+
+const (
+	// PaddingNone doesn't pad, and requires the number of leaves to be a
+	// power of two.
+	PaddingNone Padding = iota
+
+	// PaddingDuplicate duplicates the last node of the level, like the
+	// Bitcoin block merkle tree.
+	PaddingDuplicate
+
+	// PaddingZero pads the level with an all-zero node.
+	PaddingZero
+
+	// PaddingEmpty pads the level with the hash of an empty leaf.
+	PaddingEmpty
+
+	// PaddingPromote moves the last node up to the next level without
+	// hashing it, like TapBranch trees. Leaves under a promoted node get
+	// shorter proofs.
+	PaddingPromote
+)
+
+// ParsePadding parses the name of the padding strategy.
+func ParsePadding(s string) (Padding, error) {
+	switch s {
+	case "", "none":
+		return PaddingNone, nil
+	case "duplicate":
+		return PaddingDuplicate, nil
+	case "zero":
+		return PaddingZero, nil
+	case "empty":
+		return PaddingEmpty, nil
+	case "promote":
+		return PaddingPromote, nil
+	default:
+		return 0, fmt.Errorf("unknown padding %q", s)
+	}
+}
+
+// Options configure how the merkle tree is built. The zero value builds the
+// tree using sha256(left||right), requiring a power of two leaves.
+type Options struct {
+	// LeafTag, if set, is the tag used to hash leaves with BIP340 tagged
+	// hashes instead of plain sha256.
+	LeafTag string
+
+	// BranchTag, if set, is the tag used to hash branches with BIP340
+	// tagged hashes instead of plain sha256.
+	BranchTag string
+
+	// Sort sorts the children lexicographically before hashing them, like
+	// TapBranch.
+	Sort bool
 
-	var level [][32]byte
+	// Padding is the strategy used for levels with an odd number of nodes.
+	Padding Padding
+}
+
+// Tree is a merkle tree built from a list of leaves.
+type Tree struct {
+	opts Options
+
+	// levels are the levels of the tree, starting with the hashed leaves
+	// and ending with the root.
+	levels [][][32]byte
+}
+
+// New builds a merkle tree from the raw leaves, hashing them according to the
+// options. Use GroupLeaf to commit to several values in a single leaf.
+func New(leaves [][]byte, opts Options) (*Tree, error) {
+	var hashes [][32]byte
 	for _, l := range leaves {
-		if l == "" {
+		hashes = append(hashes, HashLeaf(l, opts))
+	}
+
+	return NewFromHashes(hashes, opts)
+}
+
+// NewFromHashes builds a merkle tree from already hashed leaves.
+func NewFromHashes(hashes [][32]byte, opts Options) (*Tree, error) {
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("no leaves")
+	}
+
+	level := append([][32]byte{}, hashes...)
+	levels := [][][32]byte{level}
+	for len(level) > 1 {
+		if len(level)%2 != 0 && opts.Padding == PaddingNone {
+			return nil, fmt.Errorf("invalid number of leaves")
+		}
+
+		level = pad(level, opts)
+
+		var nextLevel [][32]byte
+		for i := 1; i < len(level); i += 2 {
+			nextLevel = append(
+				nextLevel, hashBranch(level[i-1], level[i], opts),
+			)
+		}
+
+		// A promoted node moves up unchanged.
+		if len(level)%2 != 0 {
+			nextLevel = append(nextLevel, level[len(level)-1])
+		}
+
+		level = nextLevel
+		levels = append(levels, level)
+	}
+
+	return &Tree{
+		opts:   opts,
+		levels: levels,
+	}, nil
+}
+
+// Root returns the root of the tree.
+func (t *Tree) Root() [32]byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Levels returns the levels of the tree, starting with the hashed leaves and
+// ending with the root. Padding nodes are not included.
+func (t *Tree) Levels() [][][32]byte {
+	levels := make([][][32]byte, len(t.levels))
+	for i, l := range t.levels {
+		levels[i] = append([][32]byte{}, l...)
+	}
+
+	return levels
+}
+
+// Proof returns the inclusion proof for the leaf at the given index.
+func (t *Tree) Proof(index int) (*InclusionProof, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
+
+	proof := &InclusionProof{
+		Index: uint64(index),
+		Leaf:  t.levels[0][index],
+		Root:  t.Root(),
+	}
+
+	for _, level := range t.levels[:len(t.levels)-1] {
+		level = pad(level, t.opts)
+
+		// A node promoted without a sibling doesn't add to the
+		// proof.
+		if index == len(level)-1 && index%2 == 0 {
+			index /= 2
 			continue
 		}
 
-		var bs []byte
+		proof.Siblings = append(proof.Siblings, level[index^1])
+		proof.Left = append(proof.Left, index%2 == 0)
+		index /= 2
+	}
 
-		// Grouped leaves
-		if strings.HasPrefix(l, "(") &&
-			strings.HasSuffix(l, ")") {
+	return proof, nil
+}
 
-			l = strings.TrimPrefix(l, "(")
-			l = strings.TrimSuffix(l, ")")
+// Update returns a new tree where the leaf at the given index is replaced by
+// the given raw leaf.
+func (t *Tree) Update(index int, leaf []byte) (*Tree, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
 
-			els := strings.Split(l, ",")
+	hashes := append([][32]byte{}, t.levels[0]...)
+	hashes[index] = HashLeaf(leaf, t.opts)
 
-			group := bytes.Buffer{}
-			for _, el := range els {
-				h, err := hex.DecodeString(el)
-				if err != nil {
-					return nil, fmt.Errorf("unable to parse '%s': %w", l, err)
-				}
+	return NewFromHashes(hashes, t.opts)
+}
 
-				hash := sha256.Sum256(h)
-				group.Write(hash[:])
-			}
+// Merkle builds a merkle tree from the leaves, given in the format accepted by
+// ParseLeaf, using sha256(left||right). The levels of the tree are returned
+// as space separated hex strings, starting with the hashed leaves and ending
+// with the root.
+func Merkle(leaves []string) ([]string, error) {
+	parsed, err := ParseLeaves(leaves)
+	if err != nil {
+		return nil, err
+	}
 
-			bs = group.Bytes()
-		} else if l == "<>" {
-			bs = []byte{}
-		} else {
-			var err error
-			bs, err = hex.DecodeString(l)
-			if err != nil {
-				return nil, fmt.Errorf("unable to parse '%s': %w", l, err)
-			}
+	tree, err := New(parsed, Options{})
+	if err != nil {
+		return nil, err
+	}
 
+	var levels []string
+	for _, level := range tree.Levels() {
+		var s []string
+		for _, n := range level {
+			s = append(s, fmt.Sprintf("%x", n))
 		}
 
-		hash := sha256.Sum256(bs)
-		level = append(level, hash)
+		levels = append(levels, strings.Join(s, " "))
 	}
 
-	var tree []string
-	addLevel := func(level [][32]byte) {
-		s := ""
-		for i, l := range level {
-			s += fmt.Sprintf("%x", l)
-			if i < len(level)-1 {
-				s += " "
-			}
+	return levels, nil
+}
+
+// pad returns the level padded to an even number of nodes according to the
+// padding strategy. Promoted nodes are left as the odd node.
+func pad(level [][32]byte, opts Options) [][32]byte {
+	if len(level)%2 == 0 {
+		return level
+	}
+
+	var padding [32]byte
+	switch opts.Padding {
+	case PaddingDuplicate:
+		padding = level[len(level)-1]
+	case PaddingZero:
+	case PaddingEmpty:
+		padding = HashLeaf([]byte{}, opts)
+	default:
+		return level
+	}
+
+	return append(append([][32]byte{}, level...), padding)
+}
+
+// ParseLeaves parses the leaves using ParseLeaf, skipping empty strings.
+func ParseLeaves(leaves []string) ([][]byte, error) {
+	var parsed [][]byte
+	for _, l := range leaves {
+		if l == "" {
+			continue
 		}
 
-		tree = append(tree, s)
+		bs, err := ParseLeaf(l)
+		if err != nil {
+			return nil, err
+		}
 
+		parsed = append(parsed, bs)
 	}
 
-	addLevel(level)
-	for len(level) > 1 {
-		if len(level)%2 != 0 {
-			return nil, fmt.Errorf("invalid number of leaves")
+	return parsed, nil
+}
+
+// ParseLeaf parses a leaf given as hex, <> for an empty leaf, or (val1,val2)
+// for a group of values as committed to by GroupLeaf.
+func ParseLeaf(l string) ([]byte, error) {
+	switch {
+	// Grouped leaves
+	case strings.HasPrefix(l, "(") && strings.HasSuffix(l, ")"):
+		l = strings.TrimPrefix(l, "(")
+		l = strings.TrimSuffix(l, ")")
+
+		var values [][]byte
+		for _, el := range strings.Split(l, ",") {
+			h, err := hex.DecodeString(el)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse '%s': %w", l, err)
+			}
+
+			values = append(values, h)
 		}
 
-		var nextLevel [][32]byte
-		for i := 1; i < len(level); i += 2 {
-			item := make([]byte, 64)
-			copy(item[:32], level[i-1][:])
-			copy(item[32:], level[i][:])
-			hash := sha256.Sum256(item)
+		return GroupLeaf(values...), nil
 
-			nextLevel = append(nextLevel, hash)
+	case l == "<>":
+		return []byte{}, nil
+
+	default:
+		bs, err := hex.DecodeString(l)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse '%s': %w", l, err)
 		}
 
-		level = nextLevel
-		addLevel(level)
+		return bs, nil
+	}
+}
+
+// GroupLeaf returns a leaf committing to all the values, as the concatenation
+// of their sha256 hashes.
+func GroupLeaf(values ...[]byte) []byte {
+	group := bytes.Buffer{}
+	for _, v := range values {
+		hash := sha256.Sum256(v)
+		group.Write(hash[:])
 	}
 
-	return tree, nil
+	return group.Bytes()
+}
+
+// HashLeaf hashes the raw leaf according to the options.
+func HashLeaf(data []byte, opts Options) [32]byte {
+	if opts.LeafTag != "" {
+		return *chainhash.TaggedHash([]byte(opts.LeafTag), data)
+	}
+
+	return sha256.Sum256(data)
+}
+
+// hashBranch hashes the two children into their parent node.
+func hashBranch(left, right [32]byte, opts Options) [32]byte {
+	if opts.Sort && bytes.Compare(left[:], right[:]) > 0 {
+		left, right = right, left
+	}
+
+	if opts.BranchTag != "" {
+		return *chainhash.TaggedHash(
+			[]byte(opts.BranchTag), left[:], right[:],
+		)
+	}
+
+	item := make([]byte, 64)
+	copy(item[:32], left[:])
+	copy(item[32:], right[:])
+
+	return sha256.Sum256(item)
 }
@@ -0,0 +1,162 @@
+package build
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// InclusionProof proves the inclusion of a leaf in a merkle tree.
+type InclusionProof struct {
+	// Index is the index of the leaf. It is 64 bits wide, such that it
+	// fits every leaf of a sparse tree of depth MaxSparseDepth.
+	Index uint64
+
+	// Leaf is the hash of the leaf.
+	Leaf [32]byte
+
+	// Siblings are the hashes of the siblings on the path from the leaf
+	// to the root, starting at the leaf level.
+	Siblings [][32]byte
+
+	// Left holds for each sibling whether our node is the left child,
+	// meaning it is hashed as node||sibling.
+	Left []bool
+
+	// Root is the root of the tree.
+	Root [32]byte
+}
+
+// Witness returns the proof as witness elements in the format accepted by
+// script.ParseWitness. Each level is given as the sibling followed by 01 if
+// our node is the left child or <> if it is the right child. The level
+// closest to the root comes first, such that the leaf level ends up on top of
+// the stack.
+func (p *InclusionProof) Witness() string {
+	var elements []string
+	for i := len(p.Siblings) - 1; i >= 0; i-- {
+		dir := "<>"
+		if p.Left[i] {
+			dir = "01"
+		}
+
+		elements = append(elements, fmt.Sprintf("%x", p.Siblings[i]),
+			dir)
+	}
+
+	return strings.Join(elements, " ")
+}
+
+// DirectionBits returns the direction of the path from the leaf as a bit
+// string, starting at the leaf level. A 1 means our node is the left child.
+func (p *InclusionProof) DirectionBits() string {
+	var bits string
+	for _, left := range p.Left {
+		if left {
+			bits += "1"
+		} else {
+			bits += "0"
+		}
+	}
+
+	return bits
+}
+
+// ParseProof parses an inclusion proof given as witness elements, in the
+// format returned by InclusionProof.Witness. Only the siblings, directions
+// and the leaf index derived from them are set in the returned proof. The
+// index is only correct for trees without promoted nodes.
+func ParseProof(witness string) (*InclusionProof, error) {
+	elements := strings.Fields(witness)
+	if len(elements)%2 != 0 {
+		return nil, fmt.Errorf("proof must consist of sibling and " +
+			"direction pairs")
+	}
+
+	if len(elements)/2 > MaxSparseDepth {
+		return nil, fmt.Errorf("proof has %d levels, at most %d are "+
+			"supported", len(elements)/2, MaxSparseDepth)
+	}
+
+	proof := &InclusionProof{}
+
+	// The level closest to the root comes first.
+	for i := len(elements) - 2; i >= 0; i -= 2 {
+		sibling, err := hex.DecodeString(elements[i])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse '%s': %w",
+				elements[i], err)
+		}
+
+		if len(sibling) != 32 {
+			return nil, fmt.Errorf("sibling %s must be 32 bytes",
+				elements[i])
+		}
+
+		var left bool
+		switch elements[i+1] {
+		case "01":
+			left = true
+		case "<>":
+		default:
+			return nil, fmt.Errorf("direction must be 01 or <>, "+
+				"got %s", elements[i+1])
+		}
+
+		var s [32]byte
+		copy(s[:], sibling)
+		proof.Siblings = append(proof.Siblings, s)
+		proof.Left = append(proof.Left, left)
+	}
+
+	for i, left := range proof.Left {
+		if !left {
+			proof.Index |= 1 << uint(i)
+		}
+	}
+
+	return proof, nil
+}
+
+// ComputeRoot returns the root resulting from hashing the given leaf hash up
+// the tree using the siblings of the proof.
+func (p *InclusionProof) ComputeRoot(leaf [32]byte, opts Options) [32]byte {
+	node := leaf
+	for i, sibling := range p.Siblings {
+		if p.Left[i] {
+			node = hashBranch(node, sibling, opts)
+		} else {
+			node = hashBranch(sibling, node, opts)
+		}
+	}
+
+	return node
+}
+
+// Verify checks that the proof proves the inclusion of the leaf hash in the
+// tree with the given root.
+func (p *InclusionProof) Verify(leaf [32]byte, root [32]byte,
+	opts Options) error {
+
+	computed := p.ComputeRoot(leaf, opts)
+	if !bytes.Equal(computed[:], root[:]) {
+		return fmt.Errorf("proof doesn't match root: computed %x, "+
+			"expected %x", computed, root)
+	}
+
+	return nil
+}
+
+// Update returns the proof for the tree where the proven leaf is replaced by
+// the leaf with the given hash. The siblings stay the same, while the leaf
+// and root are updated.
+func (p *InclusionProof) Update(leaf [32]byte, opts Options) *InclusionProof {
+	return &InclusionProof{
+		Index:    p.Index,
+		Leaf:     leaf,
+		Siblings: p.Siblings,
+		Left:     p.Left,
+		Root:     p.ComputeRoot(leaf, opts),
+	}
+}
@@ -0,0 +1,168 @@
+package build
+
+import (
+	"fmt"
+)
+
+// MaxSparseDepth is the maximum depth of a sparse merkle tree.
+const MaxSparseDepth = 64
+
+// SparseTree is a sparse merkle tree of fixed depth, where every leaf not set
+// is the empty leaf. Only the nodes on the paths of set leaves are stored,
+// such that trees with few leaves set can have a large capacity.
+type SparseTree struct {
+	opts  Options
+	depth int
+
+	// defaults are the hashes of empty subtrees, starting with the empty
+	// leaf and ending with the root of an empty tree.
+	defaults [][32]byte
+
+	// nodes holds the nodes that differ from the empty subtree of their
+	// level, starting with the leaf level.
+	nodes []map[uint64][32]byte
+}
+
+// NewSparse creates an empty sparse merkle tree with 2^depth leaves. The
+// padding of the options is not used.
+func NewSparse(depth int, opts Options) (*SparseTree, error) {
+	if depth < 1 || depth > MaxSparseDepth {
+		return nil, fmt.Errorf("depth must be between 1 and %d",
+			MaxSparseDepth)
+	}
+
+	defaults := [][32]byte{HashLeaf([]byte{}, opts)}
+	nodes := []map[uint64][32]byte{make(map[uint64][32]byte)}
+	for i := 0; i < depth; i++ {
+		d := defaults[i]
+		defaults = append(defaults, hashBranch(d, d, opts))
+		nodes = append(nodes, make(map[uint64][32]byte))
+	}
+
+	return &SparseTree{
+		opts:     opts,
+		depth:    depth,
+		defaults: defaults,
+		nodes:    nodes,
+	}, nil
+}
+
+// Depth returns the depth of the tree.
+func (t *SparseTree) Depth() int {
+	return t.depth
+}
+
+// EmptyLeaf returns the hash of the empty leaf.
+func (t *SparseTree) EmptyLeaf() [32]byte {
+	return t.defaults[0]
+}
+
+// Root returns the root of the tree.
+func (t *SparseTree) Root() [32]byte {
+	return t.node(t.depth, 0)
+}
+
+// Get returns the hash of the leaf at the given index, and whether it is
+// set.
+func (t *SparseTree) Get(index uint64) ([32]byte, bool) {
+	leaf, ok := t.nodes[0][index]
+	if !ok {
+		return t.defaults[0], false
+	}
+
+	return leaf, true
+}
+
+// Set sets the raw leaf at the given index. Only the nodes on the path to
+// the root are updated.
+func (t *SparseTree) Set(index uint64, leaf []byte) error {
+	return t.SetHash(index, HashLeaf(leaf, t.opts))
+}
+
+// SetHash sets the already hashed leaf at the given index.
+func (t *SparseTree) SetHash(index uint64, leaf [32]byte) error {
+	if err := t.checkIndex(index); err != nil {
+		return err
+	}
+
+	node := leaf
+	for level := 0; ; level++ {
+		if node == t.defaults[level] {
+			delete(t.nodes[level], index)
+		} else {
+			t.nodes[level][index] = node
+		}
+
+		if level == t.depth {
+			return nil
+		}
+
+		sibling := t.node(level, index^1)
+		if index%2 == 0 {
+			node = hashBranch(node, sibling, t.opts)
+		} else {
+			node = hashBranch(sibling, node, t.opts)
+		}
+		index /= 2
+	}
+}
+
+// Delete resets the leaf at the given index to the empty leaf.
+func (t *SparseTree) Delete(index uint64) error {
+	return t.SetHash(index, t.defaults[0])
+}
+
+// Proof returns the proof for the leaf at the given index. If the leaf is
+// not set, this is a non-inclusion proof, proving the leaf is the empty
+// leaf.
+func (t *SparseTree) Proof(index uint64) (*InclusionProof, error) {
+	if err := t.checkIndex(index); err != nil {
+		return nil, err
+	}
+
+	leaf, _ := t.Get(index)
+	proof := &InclusionProof{
+		Index: index,
+		Leaf:  leaf,
+		Root:  t.Root(),
+	}
+
+	for level := 0; level < t.depth; level++ {
+		proof.Siblings = append(proof.Siblings, t.node(level, index^1))
+		proof.Left = append(proof.Left, index%2 == 0)
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// NonInclusionProof returns the proof that the leaf at the given index is
+// the empty leaf. An error is returned if the leaf is set.
+func (t *SparseTree) NonInclusionProof(index uint64) (*InclusionProof,
+	error) {
+
+	if _, ok := t.Get(index); ok {
+		return nil, fmt.Errorf("leaf %d is set", index)
+	}
+
+	return t.Proof(index)
+}
+
+// node returns the node at the given level and index.
+func (t *SparseTree) node(level int, index uint64) [32]byte {
+	if n, ok := t.nodes[level][index]; ok {
+		return n
+	}
+
+	return t.defaults[level]
+}
+
+// checkIndex checks that the leaf index is within the tree.
+func (t *SparseTree) checkIndex(index uint64) error {
+	if t.depth < MaxSparseDepth && index >= 1<<t.depth {
+		return fmt.Errorf("leaf index %d out of range for depth %d",
+			index, t.depth)
+	}
+
+	return nil
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/halseth/tapsim/cmd/merkle/build"
@@ -9,35 +12,337 @@ import (
 )
 
 type config struct {
-	Leaves string `short:"l" long:"leaves" description:"space separated string of hex values to commit to (must be power of 2). To group more values in single leaf, use (val1,val2)"`
+	Leaves    string `short:"l" long:"leaves" description:"space separated string of hex values to commit to (must be power of 2 unless padding is used). To group more values in single leaf, use (val1,val2)"`
+	LeafTag   string `long:"leaftag" description:"hash leaves using BIP340 tagged hashes with the given tag, e.g. TapLeaf"`
+	BranchTag string `long:"branchtag" description:"hash branches using BIP340 tagged hashes with the given tag, e.g. TapBranch"`
+	Sort      bool   `long:"sort" description:"sort children lexicographically before hashing, like TapBranch"`
+	Padding   string `long:"padding" description:"padding strategy for levels with an odd number of nodes: none, duplicate, zero, empty or promote" default:"none"`
+	Proof     int    `long:"proof" description:"print the inclusion proof for the leaf at the given index" default:"-1"`
+}
+
+// verifyCommand verifies an inclusion proof.
+type verifyCommand struct {
+	Leaf  string `long:"leaf" description:"leaf to verify inclusion of, in the same format as leaves" required:"true"`
+	Proof string `long:"proof" description:"inclusion proof as space separated witness elements, as printed when building the tree" required:"true"`
+	Root  string `long:"root" description:"merkle root in hex" required:"true"`
+}
+
+// updateCommand replaces a leaf in the tree.
+type updateCommand struct {
+	Index uint64 `long:"index" description:"index of the leaf to replace" required:"true"`
+	Leaf  string `long:"leaf" description:"new leaf, in the same format as leaves" required:"true"`
+	Proof string `long:"proof" description:"inclusion proof of the leaf to replace, used instead of leaves to compute the new root"`
+}
+
+// sparseCommand builds a sparse merkle tree.
+type sparseCommand struct {
+	Depth  int    `long:"depth" description:"depth of the tree, which has 2^depth leaves" default:"16"`
+	Leaves string `short:"l" long:"leaves" description:"space separated string of leaves to set as index:leaf, in the same format as leaves. All other leaves are empty (<>)"`
+	Proof  string `long:"proof" description:"print the inclusion proof, or non-inclusion proof if the leaf is empty, for the leaf at the given index"`
 }
 
 var cfg = config{}
 
 func main() {
-	if _, err := flags.Parse(&cfg); err != nil {
-		fmt.Println(err)
-		return
+	parser := flags.NewParser(&cfg, flags.Default)
+	parser.SubcommandsOptional = true
+
+	_, err := parser.AddCommand(
+		"verify", "verify an inclusion proof",
+		"Verify that the proof proves the inclusion of the leaf in the "+
+			"tree with the given root.",
+		&verifyCommand{},
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	err := run()
+	_, err = parser.AddCommand(
+		"update", "replace a leaf",
+		"Replace the leaf at the given index, and print the new root "+
+			"and inclusion proof. Either the leaves of the tree or "+
+			"the inclusion proof of the replaced leaf must be given.",
+		&updateCommand{},
+	)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	_, err = parser.AddCommand(
+		"sparse", "build a sparse merkle tree",
+		"Build a sparse merkle tree of the given depth, where all "+
+			"leaves not set are empty. Proofs for empty leaves "+
+			"prove non-inclusion, and can be verified using <> as "+
+			"the leaf.",
+		&sparseCommand{},
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if _, err := parser.Parse(); err != nil {
+		// The parser has already printed the error to stderr. Asking
+		// for help is not a failure.
+		if flagsErr, ok := err.(*flags.Error); ok &&
+			flagsErr.Type == flags.ErrHelp {
+
+			return
+		}
+		os.Exit(1)
+	}
+
+	// Subcommands are run by the parser.
+	if parser.Active != nil {
 		return
 	}
+
+	err = run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// options returns the build options from the config.
+func options() (build.Options, error) {
+	padding, err := build.ParsePadding(cfg.Padding)
+	if err != nil {
+		return build.Options{}, err
+	}
+
+	return build.Options{
+		LeafTag:   cfg.LeafTag,
+		BranchTag: cfg.BranchTag,
+		Sort:      cfg.Sort,
+		Padding:   padding,
+	}, nil
+}
+
+// buildTree parses the leaves from the config and builds the tree.
+func buildTree(opts build.Options) (*build.Tree, error) {
+	leaves, err := build.ParseLeaves(strings.Split(cfg.Leaves, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	return build.New(leaves, opts)
 }
 
 func run() error {
-	leaves := strings.Split(cfg.Leaves, " ")
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	tree, err := buildTree(opts)
+	if err != nil {
+		return err
+	}
+
+	printTree(tree)
+
+	if cfg.Proof < 0 {
+		return nil
+	}
+
+	proof, err := tree.Proof(cfg.Proof)
+	if err != nil {
+		return err
+	}
+
+	printProof(proof)
+	return nil
+}
+
+// printTree prints the levels of the tree, starting with the root.
+func printTree(tree *build.Tree) {
+	levels := tree.Levels()
+	for i := len(levels) - 1; i >= 0; i-- {
+		var s []string
+		for _, n := range levels[i] {
+			s = append(s, fmt.Sprintf("%x", n))
+		}
+
+		fmt.Printf("%s\n", strings.Join(s, " "))
+	}
+}
+
+// printProof prints the inclusion proof.
+func printProof(proof *build.InclusionProof) {
+	fmt.Printf("leaf: %x\n", proof.Leaf)
+	fmt.Printf("root: %x\n", proof.Root)
+	fmt.Printf("directions: %s\n", proof.DirectionBits())
+	fmt.Printf("proof: %s\n", proof.Witness())
+}
+
+// Execute verifies the inclusion proof.
+func (c *verifyCommand) Execute(_ []string) error {
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	proof, err := build.ParseProof(c.Proof)
+	if err != nil {
+		return err
+	}
+
+	rootBytes, err := hex.DecodeString(c.Root)
+	if err != nil {
+		return err
+	}
+
+	if len(rootBytes) != 32 {
+		return fmt.Errorf("root must be 32 bytes")
+	}
+
+	var root [32]byte
+	copy(root[:], rootBytes)
+
+	leaf, err := build.ParseLeaf(c.Leaf)
+	if err != nil {
+		return err
+	}
+
+	err = proof.Verify(build.HashLeaf(leaf, opts), root, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("proof verified OK for leaf index %d\n", proof.Index)
+	return nil
+}
+
+// Execute replaces the leaf and prints the new root and proof.
+func (c *updateCommand) Execute(_ []string) error {
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	leaf, err := build.ParseLeaf(c.Leaf)
+	if err != nil {
+		return err
+	}
+
+	if c.Proof != "" {
+		if cfg.Leaves != "" {
+			return fmt.Errorf("cannot set both leaves and proof")
+		}
+
+		proof, err := build.ParseProof(c.Proof)
+		if err != nil {
+			return err
+		}
+
+		if proof.Index != c.Index {
+			return fmt.Errorf("proof is for leaf index %d",
+				proof.Index)
+		}
+
+		printProof(proof.Update(build.HashLeaf(leaf, opts), opts))
+		return nil
+	}
+
+	tree, err := buildTree(opts)
+	if err != nil {
+		return err
+	}
+
+	// Indexes of leaves given in full fit in an int.
+	index := int(c.Index)
+	if index < 0 || uint64(index) != c.Index {
+		return fmt.Errorf("leaf index %d out of range", c.Index)
+	}
+
+	tree, err = tree.Update(index, leaf)
+	if err != nil {
+		return err
+	}
+
+	proof, err := tree.Proof(index)
+	if err != nil {
+		return err
+	}
+
+	var leaves []string
+	for _, l := range strings.Split(cfg.Leaves, " ") {
+		if l != "" {
+			leaves = append(leaves, l)
+		}
+	}
+	leaves[index] = c.Leaf
+
+	printTree(tree)
+	fmt.Printf("leaves: %s\n", strings.Join(leaves, " "))
+
+	printProof(proof)
+	return nil
+}
+
+// Execute builds the sparse merkle tree and prints its root and proof.
+func (c *sparseCommand) Execute(_ []string) error {
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+
+	tree, err := build.NewSparse(c.Depth, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range strings.Split(c.Leaves, " ") {
+		if l == "" {
+			continue
+		}
+
+		indexStr, leafStr, ok := strings.Cut(l, ":")
+		if !ok {
+			return fmt.Errorf("leaf must be given as index:leaf, "+
+				"got %s", l)
+		}
+
+		index, err := strconv.ParseUint(indexStr, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		leaf, err := build.ParseLeaf(leafStr)
+		if err != nil {
+			return err
+		}
+
+		if err := tree.Set(index, leaf); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("root: %x\n", tree.Root())
+	fmt.Printf("empty leaf: %x\n", tree.EmptyLeaf())
+
+	if c.Proof == "" {
+		return nil
+	}
+
+	index, err := strconv.ParseUint(c.Proof, 10, 64)
+	if err != nil {
+		return err
+	}
 
-	tree, err := build.Merkle(leaves)
+	proof, err := tree.Proof(index)
 	if err != nil {
 		return err
 	}
 
-	for i := len(tree) - 1; i >= 0; i-- {
-		fmt.Printf("%s\n", tree[i])
+	if _, ok := tree.Get(index); !ok {
+		fmt.Printf("leaf %d is empty, printing non-inclusion proof\n",
+			index)
 	}
 
+	printProof(proof)
 	return nil
 }
@@ -1,49 +1,370 @@
 package main
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"strings"
 
 	"github.com/halseth/mattlab/commitment"
+	flags "github.com/jessevdk/go-flags"
 )
 
+const usage = "[OPTIONS] <num>..."
+
+const description = "Converts numbers to the CScriptNum format. If no " +
+	"numbers are given to this or any of the commands, they are read " +
+	"from stdin. Negative numbers must come after the options."
+
+const (
+	// mathLen is the maximum length of numbers used by arithmetic
+	// opcodes.
+	mathLen = 4
+
+	// lockTimeLen is the maximum length of numbers used by
+	// OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY.
+	lockTimeLen = 5
+
+	// maxLen is the maximum length of numbers we can decode.
+	maxLen = 8
+)
+
+type config struct {
+	Strict bool `long:"strict" description:"fail instead of warning if a number exceeds the 4 byte limit of arithmetic opcodes"`
+}
+
+// fromCommand decodes CScriptNums.
+type fromCommand struct {
+	Minimal bool `long:"minimal" description:"fail instead of warning if a number is not minimally encoded"`
+}
+
+// leCommand encodes little-endian amounts.
+type leCommand struct {
+	Size int `long:"size" description:"size of the amount in bytes, 4 for uint32 or 8 for uint64" default:"8"`
+}
+
+// fromLECommand decodes little-endian amounts.
+type fromLECommand struct {
+	ScriptNum bool `long:"scriptnum" description:"print the amount as CScriptNum instead of decimal"`
+}
+
+var cfg = config{}
+
 func main() {
-	val := os.Args[1]
-	var (
-		num uint64
-		err error
+	parser := flags.NewParser(&cfg, flags.Default)
+	parser.Usage = usage
+	parser.LongDescription = description
+	parser.SubcommandsOptional = true
+
+	_, err := parser.AddCommand(
+		"from", "decode CScriptNums",
+		"Decode hex encoded CScriptNums to decimal, checking they are "+
+			"minimally encoded. Use <> for the empty encoding of "+
+			"zero.",
+		&fromCommand{},
 	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	if val == "from" {
-		scriptNumHex := os.Args[2]
-		v, err := hex.DecodeString(scriptNumHex)
-		if err != nil {
-			panic(err)
-		}
+	_, err = parser.AddCommand(
+		"le", "encode little-endian amounts",
+		"Encode unsigned numbers as fixed size little-endian amounts, "+
+			"like output values committed to in transactions.",
+		&leCommand{},
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-		scriptNum, err := commitment.MakeScriptNum(v, true, len(v))
-		if err != nil {
-			panic(err)
+	_, err = parser.AddCommand(
+		"fromle", "decode little-endian amounts",
+		"Decode hex encoded 4 or 8 byte little-endian amounts to "+
+			"decimal, or to CScriptNum for use in arithmetic.",
+		&fromLECommand{},
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	args, err := parser.ParseArgs(negativeArgs(os.Args[1:]))
+	if err != nil {
+		// The parser has already printed the error to stderr. Asking
+		// for help is not a failure.
+		if flagsErr, ok := err.(*flags.Error); ok &&
+			flagsErr.Type == flags.ErrHelp {
+
+			return
 		}
+		os.Exit(1)
+	}
 
-		fmt.Printf("%d\n", scriptNum)
+	// Subcommands are run by the parser.
+	if parser.Active != nil {
 		return
 	}
 
+	err = run(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// negativeArgs inserts -- before the first negative number, such that it is
+// not parsed as a flag. Flags must therefore come before negative numbers.
+func negativeArgs(args []string) []string {
+	for i, a := range args {
+		if a == "--" {
+			return args
+		}
+
+		if len(a) > 1 && a[0] == '-' && a[1] >= '0' && a[1] <= '9' {
+			return append(append(args[:i:i], "--"), args[i:]...)
+		}
+	}
+
+	return args
+}
+
+// batch calls f for every argument, or for every whitespace separated value
+// read from stdin if there are no arguments.
+func batch(args []string, f func(string) error) error {
+	if len(args) > 0 {
+		for _, a := range args {
+			if err := f(a); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		if err := f(scanner.Text()); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// warn prints the warning to stderr, keeping stdout to the converted values.
+func warn(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", a...)
+}
+
+// checkLen warns if the encoded number exceeds the limits of the opcodes
+// using numbers, or fails if strict is set.
+func checkLen(val string, n int) error {
+	switch {
+	case n > mathLen && cfg.Strict:
+		return fmt.Errorf("%s is %d bytes, exceeding the %d byte "+
+			"limit of arithmetic opcodes", val, n, mathLen)
+
+	case n > lockTimeLen:
+		warn("%s is %d bytes, exceeding the %d byte limit of "+
+			"arithmetic opcodes and the %d byte limit of "+
+			"OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY",
+			val, n, mathLen, lockTimeLen)
+
+	case n > mathLen:
+		warn("%s is %d bytes, exceeding the %d byte limit of "+
+			"arithmetic opcodes. It can only be used by "+
+			"OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY, or "+
+			"as result of arithmetic", val, n, mathLen)
+	}
+
+	return nil
+}
+
+// parseInt parses the decimal or 0x prefixed hex number, which can be
+// negative.
+func parseInt(val string) (int64, error) {
+	if strings.HasPrefix(val, "--") {
+		return 0, fmt.Errorf("flag %s must come before negative "+
+			"numbers", val)
+	}
+
+	s := val
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	base := 10
+	if strings.HasPrefix(s, "0x") {
+		base = 16
+		s = strings.TrimPrefix(s, "0x")
+	}
+
+	if neg {
+		s = "-" + s
+	}
+
+	return strconv.ParseInt(s, base, 64)
+}
+
+// parseUint parses the decimal or 0x prefixed hex unsigned number.
+func parseUint(val string) (uint64, error) {
 	base := 10
 	if strings.HasPrefix(val, "0x") {
 		base = 16
 		val = strings.TrimPrefix(val, "0x")
 	}
-	num, err = strconv.ParseUint(val, base, 64)
-	if err != nil {
-		panic(err)
+
+	return strconv.ParseUint(val, base, 64)
+}
+
+// encode returns the hex encoding of the bytes, or <> if empty.
+func encode(b []byte) string {
+	if len(b) == 0 {
+		return "<>"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// decode decodes the hex string, where <> is empty.
+func decode(s string) ([]byte, error) {
+	if s == "<>" {
+		return nil, nil
 	}
-	s := commitment.ScriptNum(num)
 
-	fmt.Printf("%x\n", s.Bytes())
+	return hex.DecodeString(s)
+}
+
+// run encodes the numbers as CScriptNums.
+func run(args []string) error {
+	return batch(args, func(val string) error {
+		num, err := parseInt(val)
+		if err != nil {
+			return err
+		}
+
+		// The magnitude of the smallest int64 doesn't fit in 8 bytes.
+		if num == math.MinInt64 {
+			return fmt.Errorf("%s is too small, can encode at most "+
+				"%d bytes", val, maxLen)
+		}
+
+		b := commitment.ScriptNum(num).Bytes()
+		if err := checkLen(val, len(b)); err != nil {
+			return err
+		}
+
+		fmt.Println(encode(b))
+		return nil
+	})
+}
+
+// Execute decodes the CScriptNums.
+func (c *fromCommand) Execute(args []string) error {
+	return batch(args, func(val string) error {
+		v, err := decode(val)
+		if err != nil {
+			return err
+		}
+
+		if len(v) > maxLen {
+			return fmt.Errorf("%s is %d bytes, can decode at most %d",
+				val, len(v), maxLen)
+		}
+
+		num, err := commitment.MakeScriptNum(v, false, maxLen)
+		if err != nil {
+			return err
+		}
+
+		_, err = commitment.MakeScriptNum(v, true, maxLen)
+		switch {
+		case err != nil && c.Minimal:
+			return fmt.Errorf("%s is not minimally encoded, "+
+				"expected %s", val, encode(num.Bytes()))
+
+		case err != nil:
+			warn("%s is not minimally encoded, and fails with "+
+				"MINIMALDATA. Minimal encoding is %s", val,
+				encode(num.Bytes()))
+		}
+
+		if err := checkLen(val, len(v)); err != nil {
+			return err
+		}
+
+		fmt.Printf("%d\n", num)
+		return nil
+	})
+}
+
+// Execute encodes the amounts as little-endian.
+func (c *leCommand) Execute(args []string) error {
+	if c.Size != 4 && c.Size != 8 {
+		return fmt.Errorf("size must be 4 or 8")
+	}
+
+	return batch(args, func(val string) error {
+		num, err := parseUint(val)
+		if err != nil {
+			return err
+		}
+
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, num)
+		if c.Size == 4 {
+			if num > math.MaxUint32 {
+				return fmt.Errorf("%s doesn't fit in 4 bytes",
+					val)
+			}
+			b = b[:4]
+		}
+
+		fmt.Println(hex.EncodeToString(b))
+		return nil
+	})
+}
+
+// Execute decodes the little-endian amounts.
+func (c *fromLECommand) Execute(args []string) error {
+	return batch(args, func(val string) error {
+		v, err := hex.DecodeString(val)
+		if err != nil {
+			return err
+		}
+
+		var num uint64
+		switch len(v) {
+		case 4:
+			num = uint64(binary.LittleEndian.Uint32(v))
+		case 8:
+			num = binary.LittleEndian.Uint64(v)
+		default:
+			return fmt.Errorf("%s must be 4 or 8 bytes", val)
+		}
+
+		if !c.ScriptNum {
+			fmt.Printf("%d\n", num)
+			return nil
+		}
+
+		if num > math.MaxInt64 {
+			return fmt.Errorf("%s is too large for a CScriptNum",
+				val)
+		}
+
+		b := commitment.ScriptNum(int64(num)).Bytes()
+		if err := checkLen(val, len(b)); err != nil {
+			return err
+		}
 
+		fmt.Println(encode(b))
+		return nil
+	})
 }
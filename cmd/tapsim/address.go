@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/halseth/tapsim/address"
+	"github.com/urfave/cli/v2"
+)
+
+func decodeAddress(cCtx *cli.Context) error {
+	addrStr := cCtx.String("address")
+	if cCtx.NArg() > 0 {
+		addrStr = cCtx.Args().Get(0)
+	}
+
+	if addrStr == "" {
+		return fmt.Errorf("must set address")
+	}
+
+	info, err := address.Decode(addrStr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("network: %s\n", info.Network)
+	fmt.Printf("type: %s\n", info.Type)
+	if info.IsWitness {
+		fmt.Printf("witness version: %d\n", info.WitnessVersion)
+		fmt.Printf("witness program: %x\n", info.WitnessProgram)
+	}
+	fmt.Printf("output script: %x\n", info.PkScript)
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/halseth/tapsim/script"
+	"github.com/urfave/cli/v2"
+)
+
+func build(cCtx *cli.Context) error {
+	input := script.TxInput{
+		Value: cCtx.Int64("inputvalue"),
+	}
+
+	// Outputs not given with values spend the input value minus a fee,
+	// unlike execute, which keeps the value for OP_CHECKCONTRACTVERIFY.
+	if cCtx.String("outputs") == "" && input.Value <= script.DefaultFee {
+		return fmt.Errorf("input value %d too small to pay the fee of "+
+			"%d sats", input.Value, script.DefaultFee)
+	}
+
+	sp, err := parseSpend(cCtx, script.DefaultFee)
+	if err != nil {
+		return err
+	}
+
+	if len(sp.outputs) == 0 {
+		privKey, err := btcec.NewPrivateKey()
+		if err != nil {
+			return err
+		}
+
+		sp.outputs = []script.TxOutput{{
+			OutputKey: privKey.PubKey(),
+			Value:     input.Value - script.DefaultFee,
+		}}
+	}
+
+	if o := cCtx.String("outpoint"); o != "" {
+		op, err := wire.NewOutPointFromString(o)
+		if err != nil {
+			return err
+		}
+
+		input.OutPoint = *op
+	}
+
+	tx, prevOuts, err := script.BuildTx(
+		sp.privKeys, sp.inputKey, sp.inputData, input, sp.outputs,
+		sp.scripts, sp.tapTree, sp.scriptIndex, sp.scriptType,
+		sp.witness, sp.chainParams,
+	)
+	if err != nil {
+		return err
+	}
+
+	// A transaction paying no fee is valid, but won't be relayed.
+	var outputValue int64
+	for _, o := range tx.TxOut {
+		outputValue += o.Value
+	}
+
+	switch {
+	case outputValue > input.Value:
+		return fmt.Errorf("outputs spend %d sats, more than the input "+
+			"value of %d sats", outputValue, input.Value)
+
+	case outputValue == input.Value:
+		fmt.Fprintf(os.Stderr, "warning: transaction pays no fee, "+
+			"and will not be relayed\n")
+	}
+
+	var txBuf bytes.Buffer
+	if err := tx.Serialize(&txBuf); err != nil {
+		return err
+	}
+
+	// Serialize the prevouts in the format accepted by --prevouts.
+	var prevOutStrs []string
+	for _, p := range prevOuts {
+		var b bytes.Buffer
+		if err := wire.WriteTxOut(&b, 0, 0, p); err != nil {
+			return err
+		}
+
+		prevOutStrs = append(prevOutStrs, hex.EncodeToString(b.Bytes()))
+	}
+
+	fmt.Printf("tx: %x\n", txBuf.Bytes())
+	fmt.Printf("txid: %v\n", tx.TxHash())
+	fmt.Printf("prevouts: %s\n", strings.Join(prevOutStrs, ","))
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/halseth/tapsim/miniscript"
+	"github.com/urfave/cli/v2"
+)
+
+func compile(cCtx *cli.Context) error {
+	expr := cCtx.String("expr")
+	if cCtx.NArg() > 0 {
+		expr = cCtx.Args().Get(0)
+	}
+
+	if expr == "" {
+		return fmt.Errorf("must set expression to compile")
+	}
+
+	privKeys, err := parsePrivKeys(cCtx, nil, "")
+	if err != nil {
+		return err
+	}
+
+	// Keys named in privkeys are replaced by their public key. Keys
+	// without a private key must be given as hex public keys.
+	pubKeys := make(map[string][]byte)
+	keys := func(k string) ([]byte, error) {
+		if pub, ok := pubKeys[k]; ok {
+			return pub, nil
+		}
+
+		privKeyBytes, ok := privKeys[k]
+		if !ok {
+			return miniscript.HexKey(k)
+		}
+
+		var privKey *btcec.PrivateKey
+		if len(privKeyBytes) == 0 {
+			privKey, err = btcec.NewPrivateKey()
+			if err != nil {
+				return nil, err
+			}
+			fmt.Printf("generated key %s: %x\n", k,
+				privKey.Serialize())
+		} else {
+			privKey, _ = btcec.PrivKeyFromBytes(privKeyBytes)
+		}
+
+		pubKeys[k] = schnorr.SerializePubKey(privKey.PubKey())
+		return pubKeys[k], nil
+	}
+
+	var node *miniscript.Node
+	if cCtx.Bool("policy") {
+		node, err = miniscript.CompilePolicy(expr)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("miniscript: %s\n", node)
+	} else {
+		node, err = miniscript.Parse(expr)
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := node.Script(keys)
+	if err != nil {
+		return err
+	}
+
+	witness, err := node.Satisfy(keys)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("script: %s\n", strings.Join(s, " "))
+	fmt.Printf("witness: %s\n", strings.Join(witness, " "))
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/halseth/tapsim/contract"
+	"github.com/halseth/tapsim/output"
+	"github.com/halseth/tapsim/script"
+	"github.com/urfave/cli/v2"
+)
+
+// contractRun runs the transitions of a contract description, reporting the
+// state after each of them.
+func contractRun(cCtx *cli.Context) error {
+	contractFile := cCtx.String("contract")
+	if cCtx.NArg() > 0 {
+		contractFile = cCtx.Args().Get(0)
+	}
+
+	if contractFile == "" {
+		return fmt.Errorf("contract file must be set")
+	}
+
+	c, err := contract.Load(contractFile)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range c.States {
+		key, err := s.Key()
+		if err != nil {
+			return err
+		}
+
+		tree, err := s.TapTree()
+		if err != nil {
+			return err
+		}
+
+		root := tree.RootNode.TapHash()
+		fmt.Printf("state %s: internal key %x taptree %x\n", s.Name,
+			schnorr.SerializePubKey(key), root[:])
+	}
+
+	start, err := c.StartOutput()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("start: %s\n", describeOutput(start))
+
+	// Report the state after each transition as it is run.
+	report := func(i int, res *contract.Result) {
+		t := res.Transition
+		name := t.Name
+		if name == "" {
+			name = t.Leaf
+		}
+
+		fmt.Printf("transition %d (%s): spent input %d using leaf "+
+			"%s\n", i, name, t.Input, t.Leaf)
+		fmt.Printf("txid: %v\n", res.Tx.TxHash())
+
+		if res.Err != nil {
+			fmt.Printf("transition %d FAILED\n", i)
+			return
+		}
+
+		for j, o := range res.Outputs {
+			fmt.Printf("output[%d]: %s\n", j, describeOutput(o))
+		}
+		fmt.Printf("transition %d verified\n", i)
+	}
+
+	results, err := c.Run(report)
+	if err != nil {
+		return err
+	}
+
+	last := results[len(results)-1]
+	if last.Err == nil {
+		fmt.Printf("all %d transitions verified\n", len(results))
+		return nil
+	}
+
+	if !cCtx.Bool("debug") {
+		printFailure(last.Err)
+		return last.Err
+	}
+
+	// Step through the failing transaction.
+	output.Color = output.ColorSupported()
+	err = script.ExecuteTx(
+		last.Tx, last.PrevOuts, 0, true, false, nil, 0, nil, nil,
+	)
+	if err != nil {
+		printFailure(err)
+	}
+
+	return last.Err
+}
+
+// describeOutput returns a single line description of the contract output.
+func describeOutput(o *contract.OutputState) string {
+	desc := fmt.Sprintf("value %d output key %x outpoint %v", o.Value,
+		schnorr.SerializePubKey(o.OutputKey), o.OutPoint)
+
+	if o.State == "" {
+		return "plain " + desc
+	}
+
+	data := o.Data
+	if data == "" {
+		data = "<>"
+	}
+
+	return fmt.Sprintf("state %s data %s internal key %x %s", o.State,
+		data, schnorr.SerializePubKey(o.Keys.InternalKey), desc)
+}
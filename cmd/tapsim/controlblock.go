@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/halseth/tapsim/file"
+	"github.com/halseth/tapsim/script"
+	"github.com/urfave/cli/v2"
+)
+
+func controlBlock(cCtx *cli.Context) error {
+	var (
+		ctrlBlock  []byte
+		leafScript []byte
+		outputKey  []byte
+		err        error
+	)
+
+	if txStr := cCtx.String("tx"); txStr != "" {
+		if cCtx.String("controlblock") != "" ||
+			cCtx.String("script") != "" {
+
+			return fmt.Errorf("cannot set controlblock or script " +
+				"together with tx")
+		}
+
+		tx, err := parseTx(txStr)
+		if err != nil {
+			return err
+		}
+
+		inputIndex := cCtx.Int("inputindex")
+		if inputIndex < 0 || inputIndex >= len(tx.TxIn) {
+			return fmt.Errorf("input index %d out of range",
+				inputIndex)
+		}
+
+		leafScript, ctrlBlock, err = script.TapscriptSpend(
+			tx.TxIn[inputIndex].Witness,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Take the output key from the prevout if available.
+		prevOuts, err := parsePrevOuts(cCtx.String("prevouts"))
+		if err != nil {
+			return err
+		}
+
+		if inputIndex < len(prevOuts) && prevOuts[inputIndex] != nil {
+			pkScript := prevOuts[inputIndex].PkScript
+			if !txscript.IsPayToTaproot(pkScript) {
+				return fmt.Errorf("prevout %d is not a taproot "+
+					"output", inputIndex)
+			}
+
+			outputKey = pkScript[2:]
+		}
+	} else {
+		ctrlBlock, err = hex.DecodeString(cCtx.String("controlblock"))
+		if err != nil {
+			return err
+		}
+
+		scriptStr := cCtx.String("script")
+
+		// Attempt to read the script from file.
+		scriptBytes, err := file.Read(scriptStr)
+		if err == nil {
+			scriptStr, err = file.ParseScript(scriptBytes)
+			if err != nil {
+				return err
+			}
+		}
+
+		leafScript, err = script.Parse(scriptStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if k := cCtx.String("outputkey"); k != "" {
+		outputKey, err = hex.DecodeString(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	info, err := script.InspectControlBlock(ctrlBlock, leafScript)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("leaf version: %#x\n", byte(info.LeafVersion))
+	fmt.Printf("output key parity: %s\n", parity(info.ControlBlock.OutputKeyYIsOdd))
+	fmt.Printf("internal key: %x\n", schnorr.SerializePubKey(info.InternalKey))
+	for i := 0; i < len(info.InclusionProof); i += 32 {
+		fmt.Printf("path[%d]: %x\n", i/32, info.InclusionProof[i:i+32])
+	}
+
+	fmt.Printf("leaf hash: %x\n", info.LeafHash[:])
+	for i, s := range info.Steps {
+		fmt.Printf("branch[%d]: TapBranch(%x, %x) = %x\n", i,
+			s.Node[:], s.Sibling[:], s.Branch[:])
+	}
+	fmt.Printf("merkle root: %x\n", info.MerkleRoot[:])
+	fmt.Printf("computed output key: %x (%s)\n",
+		schnorr.SerializePubKey(info.OutputKey),
+		parity(info.ComputedYIsOdd()))
+
+	if len(outputKey) == 0 {
+		return nil
+	}
+
+	if err := info.VerifyOutputKey(outputKey); err != nil {
+		return err
+	}
+
+	fmt.Printf("control block verified OK against output key %x\n",
+		outputKey)
+	return nil
+}
+
+// parity returns a description of the y coordinate parity.
+func parity(odd bool) string {
+	if odd {
+		return "odd"
+	}
+
+	return "even"
+}
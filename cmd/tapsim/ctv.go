@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/halseth/tapsim/ctv"
+	"github.com/halseth/tapsim/script"
+	"github.com/urfave/cli/v2"
+)
+
+// ctvHash computes the BIP119 template hash of a transaction, either given
+// directly or built from the spend flags, and optionally compares it against
+// an expected hash or transaction.
+func ctvHash(cCtx *cli.Context) error {
+	var tx *wire.MsgTx
+	if txStr := cCtx.String("tx"); txStr != "" {
+		var err error
+		tx, err = parseTx(txStr)
+		if err != nil {
+			return err
+		}
+	} else {
+		sp, err := parseSpend(cCtx, 0)
+		if err != nil {
+			return err
+		}
+		tx, _, err = script.BuildTx(
+			sp.privKeys, sp.inputKey, sp.inputData,
+			script.TxInput{Value: 1e8}, sp.outputs, sp.scripts,
+			sp.tapTree, sp.scriptIndex, sp.scriptType, sp.witness,
+			sp.chainParams,
+		)
+		if err != nil {
+			return err
+		}
+
+		var txBuf bytes.Buffer
+		if err := tx.Serialize(&txBuf); err != nil {
+			return err
+		}
+		fmt.Printf("tx: %x\n", txBuf.Bytes())
+	}
+
+	tmpl, err := ctv.NewTemplate(tx, cCtx.Int("inputindex"))
+	if err != nil {
+		return err
+	}
+
+	// Without an expected transaction we just print the template.
+	expectTx := cCtx.String("expecttx")
+	if expectTx == "" {
+		for _, f := range tmpl.Fields() {
+			fmt.Printf("%s: %s\n", f.Name, f.Value)
+		}
+
+		return checkHash(tmpl, cCtx.String("expect"))
+	}
+
+	exp, err := parseTx(expectTx)
+	if err != nil {
+		return err
+	}
+
+	expIndex := cCtx.Int("inputindex")
+	if cCtx.IsSet("expectindex") {
+		expIndex = cCtx.Int("expectindex")
+	}
+
+	expTmpl, err := ctv.NewTemplate(exp, expIndex)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range tmpl.Compare(expTmpl) {
+		if !f.Differs() {
+			fmt.Printf("  %s: %s\n", f.Name, f.Value)
+			continue
+		}
+
+		fmt.Printf("! %s: %s (expected %s)\n", f.Name, f.Value,
+			f.Expected)
+	}
+
+	expHash := expTmpl.Hash()
+	return checkHash(tmpl, hex.EncodeToString(expHash[:]))
+}
+
+// checkHash checks the template hash against the expected hex encoded hash,
+// if set.
+func checkHash(tmpl *ctv.Template, expected string) error {
+	if expected == "" {
+		return nil
+	}
+
+	hash := tmpl.Hash()
+	if hex.EncodeToString(hash[:]) != expected {
+		return fmt.Errorf("template hash %x doesn't match expected %s",
+			hash[:], expected)
+	}
+
+	fmt.Printf("template hash matches\n")
+	return nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/halseth/tapsim/dap"
+	"github.com/halseth/tapsim/file"
+	"github.com/halseth/tapsim/script"
+	"github.com/urfave/cli/v2"
+)
+
+// launchFlags are the flags that can be given as launch arguments to the
+// debug adapter, under the same names as for the execute command.
+var launchFlags = append(spendFlags, []cli.Flag{
+	&cli.StringFlag{
+		Name: "tx",
+	},
+	&cli.StringFlag{
+		Name: "prevouts",
+	},
+	&cli.StringFlag{
+		Name: "psbt",
+	},
+	&cli.IntFlag{
+		Name: "inputindex",
+	},
+	&cli.StringFlag{
+		Name: "tagfile",
+	},
+}...)
+
+// launchConfig holds the launch arguments that are not flags.
+type launchConfig struct {
+	// Mode is "script" to build a transaction spending the script with
+	// the witness, or "tx" to execute an input of a tx or psbt. It is
+	// inferred from the arguments if not set.
+	Mode string `json:"mode"`
+
+	// StopOnEntry stops execution at the first step.
+	StopOnEntry bool `json:"stopOnEntry"`
+
+	// Cwd is the directory relative file names are resolved from.
+	Cwd string `json:"cwd"`
+}
+
+// fileLaunchArgs are the launch arguments that can be file names, resolved
+// relative to the cwd launch argument. The value is set for arguments that
+// are lists of file names separated by commas.
+var fileLaunchArgs = map[string]bool{
+	"script":  false,
+	"scripts": true,
+	"witness": false,
+	"psbt":    false,
+	"tagfile": false,
+	"keyring": false,
+}
+
+// ignoredLaunchArgs are launch arguments set by editors that we don't use.
+var ignoredLaunchArgs = map[string]bool{
+	"type":         true,
+	"request":      true,
+	"name":         true,
+	"noDebug":      true,
+	"presentation": true,
+}
+
+func dapServer(cCtx *cli.Context) error {
+	addr := cCtx.String("listen")
+	if addr == "" {
+		return dap.Serve(os.Stdin, os.Stdout, launch)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	fmt.Fprintf(os.Stderr, "listening on %s\n", l.Addr())
+
+	// Serve one client at a time.
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		if err := dap.Serve(c, c, launch); err != nil {
+			fmt.Fprintf(os.Stderr, "session failed: %v\n", err)
+		}
+		c.Close()
+	}
+}
+
+// launch creates the program to debug from the launch arguments. The
+// arguments are the flags of the execute command, in addition to the fields
+// of launchConfig.
+func launch(args json.RawMessage, w io.Writer) (*dap.Program, error) {
+	var cfg launchConfig
+	if err := json.Unmarshal(args, &cfg); err != nil {
+		return nil, err
+	}
+
+	cCtx, err := launchContext(args, cfg.Cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := cfg.Mode
+	if mode == "" {
+		mode = "script"
+		if cCtx.String("tx") != "" || cCtx.String("psbt") != "" {
+			mode = "tx"
+		}
+	}
+
+	tags, err := readTags(cCtx.String("tagfile"))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		tx       *wire.MsgTx
+		prevOuts []*wire.TxOut
+		source   *dap.Source
+	)
+	switch mode {
+	case "tx":
+		txStr := cCtx.String("tx")
+		psbtStr := cCtx.String("psbt")
+
+		switch {
+		case txStr != "" && psbtStr != "":
+			return nil, fmt.Errorf("cannot set both tx and psbt")
+
+		case txStr != "":
+			tx, err = parseTx(txStr)
+			if err != nil {
+				return nil, err
+			}
+
+			prevOuts, err = parsePrevOuts(cCtx.String("prevouts"))
+			if err != nil {
+				return nil, err
+			}
+
+		case psbtStr != "":
+			tx, prevOuts, tags, err = readPsbt(psbtStr, tags)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("tx mode needs tx or psbt")
+		}
+
+	case "script":
+		sp, err := parseSpend(cCtx, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(w, "Script: %s\n", sp.scriptStr[sp.scriptIndex])
+		fmt.Fprintf(w, "Witness: %s\n", sp.witnessStr)
+
+		tx, prevOuts, err = script.Build(sp.options(), w)
+		if err != nil {
+			return nil, err
+		}
+
+		source, err = scriptSource(cCtx, sp.scriptIndex)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown mode %q, must be script or tx",
+			mode)
+	}
+
+	return &dap.Program{
+		Tx:          tx,
+		PrevOuts:    prevOuts,
+		InputIndex:  cCtx.Int("inputindex"),
+		Source:      source,
+		Tags:        tags,
+		StopOnEntry: cfg.StopOnEntry,
+	}, nil
+}
+
+// launchContext returns a context with the launch arguments set as flags,
+// such that they can be parsed like the flags of the execute command. File
+// names are resolved relative to cwd.
+func launchContext(args json.RawMessage, cwd string) (*cli.Context, error) {
+	set := flag.NewFlagSet("launch", flag.ContinueOnError)
+	for _, f := range launchFlags {
+		if err := f.Apply(set); err != nil {
+			return nil, err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.UseNumber()
+
+	var kv map[string]interface{}
+	if err := dec.Decode(&kv); err != nil {
+		return nil, err
+	}
+
+	for k, v := range kv {
+		if ignoredLaunchArgs[k] || strings.HasPrefix(k, "__") {
+			continue
+		}
+
+		switch k {
+		case "mode", "stopOnEntry", "cwd":
+			continue
+		}
+
+		if set.Lookup(k) == nil {
+			return nil, fmt.Errorf("unknown launch argument %q", k)
+		}
+
+		var value string
+		switch v := v.(type) {
+		// Lists are given like on the command line, where the witness
+		// is separated by spaces, and other lists by commas.
+		case []interface{}:
+			sep := ","
+			if k == "witness" {
+				sep = " "
+			}
+
+			var elements []string
+			for _, e := range v {
+				elements = append(elements, fmt.Sprint(e))
+			}
+			value = strings.Join(elements, sep)
+
+		default:
+			value = fmt.Sprint(v)
+		}
+
+		if list, ok := fileLaunchArgs[k]; ok {
+			value = resolvePaths(cwd, value, list)
+		}
+
+		if err := set.Set(k, value); err != nil {
+			return nil, fmt.Errorf("invalid launch argument %q: %w",
+				k, err)
+		}
+	}
+
+	return cli.NewContext(nil, set, nil), nil
+}
+
+// resolvePaths resolves the file name, or comma separated file names if list
+// is set, relative to cwd. Since most file arguments can also be given
+// directly, like the script itself, names are only changed if there is a file
+// relative to cwd.
+func resolvePaths(cwd, value string, list bool) string {
+	if cwd == "" {
+		return value
+	}
+
+	names := []string{value}
+	if list {
+		names = strings.Split(value, ",")
+	}
+
+	for i, name := range names {
+		if name == "" || filepath.IsAbs(name) {
+			continue
+		}
+
+		path := filepath.Join(cwd, name)
+		if _, err := os.Stat(path); err == nil {
+			names[i] = path
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// scriptSource returns the source file of the executed script, if the script
+// was read from file.
+func scriptSource(cCtx *cli.Context, scriptIndex int) (*dap.Source, error) {
+	var fileName string
+	switch {
+	case cCtx.String("script") != "":
+		fileName = cCtx.String("script")
+
+	case cCtx.String("scripts") != "":
+		var files []string
+		for _, f := range strings.Split(cCtx.String("scripts"), ",") {
+			if f != "" {
+				files = append(files, f)
+			}
+		}
+		fileName = files[scriptIndex]
+
+	default:
+		return nil, nil
+	}
+
+	data, err := file.Read(fileName)
+	if err != nil {
+		// The script was given directly.
+		return nil, nil
+	}
+
+	path, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dap.Source{
+		Path:  path,
+		Lines: file.ScriptLines(data),
+	}, nil
+}
@@ -3,13 +3,13 @@ package main
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"strconv"
 	"strings"
 
-	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/wire"
 
 	"github.com/halseth/tapsim/file"
@@ -46,19 +46,7 @@ func main() {
 			Description: "",
 			ArgsUsage:   "",
 			Action:      execute,
-			Flags: []cli.Flag{
-				&cli.StringFlag{
-					Name:  "script",
-					Usage: "filename or output script as string",
-				},
-				&cli.StringFlag{
-					Name:  "scripts",
-					Usage: "list of filenames with output scripts to assemble into a taptree",
-				},
-				&cli.IntFlag{
-					Name:  "scriptindex",
-					Usage: "index of script from \"scripts\" to execute",
-				},
+			Flags: append(spendFlags, []cli.Flag{
 				&cli.StringFlag{
 					Name:  "tx",
 					Usage: "serialized transaction in hex",
@@ -67,13 +55,17 @@ func main() {
 					Name:  "prevouts",
 					Usage: "serialized prevouts comma seperated. Must be in same order as tx inputs",
 				},
+				&cli.StringFlag{
+					Name:  "psbt",
+					Usage: "filename or PSBT in base64 or hex. Prevouts, witnesses and tags are taken from the PSBT",
+				},
 				&cli.IntFlag{
 					Name:  "inputindex",
 					Usage: "index of input from \"tx\" to execute",
 				},
-				&cli.StringFlag{
-					Name:  "witness",
-					Usage: "filename or witness stack as string",
+				&cli.BoolFlag{
+					Name:  "all-inputs",
+					Usage: "validate all inputs of \"tx\", then step through the first failing one",
 				},
 				&cli.BoolFlag{
 					Name:    "non-interactive",
@@ -86,24 +78,6 @@ func main() {
 					Usage:   "don't show step by step, just validate",
 				},
 
-				&cli.StringFlag{
-					Name:  "privkeys",
-					Usage: "specify private keys as \"key1:<hex>,key2:<hex>\" to sign the transaction. Set <hex> empty to generate a random key with the given ID.",
-				},
-
-				&cli.StringFlag{
-					Name:  "inputkey",
-					Usage: "use specified internal key for the input",
-				},
-				&cli.StringFlag{
-					Name:  "outputkey",
-					Usage: "use specified internal key for the output",
-				},
-				&cli.StringFlag{
-					Name:  "outputs",
-					Usage: "specify taproot outputs as \"<pubkey>:<value>\"",
-				},
-
 				&cli.StringFlag{
 					Name:  "tagfile",
 					Usage: "optional json file map from hex values to human-readable tags",
@@ -122,6 +96,163 @@ func main() {
 					Name:  "skip",
 					Usage: "skip ahead",
 				},
+				&cli.BoolFlag{
+					Name:  "no-color",
+					Usage: "mark stack changes with +/-/~ instead of using colors",
+				},
+				&cli.StringFlag{
+					Name:  "trace",
+					Usage: "write the VM state at every step as JSON lines to the given file",
+				},
+				&cli.StringFlag{
+					Name:  "report",
+					Usage: "write a self-contained HTML report of the complete execution to the given file",
+				},
+			}...),
+		},
+		{
+			Name:   "build",
+			Usage:  "build and sign a transaction spending the script",
+			Action: build,
+			Flags: append(spendFlags, []cli.Flag{
+				&cli.StringFlag{
+					Name:  "outpoint",
+					Usage: "outpoint to spend as \"<txid>:<index>\"",
+				},
+				&cli.Int64Flag{
+					Name:  "inputvalue",
+					Usage: "value in sats of the output being spent",
+					Value: 1e8,
+				},
+			}...),
+		},
+		{
+			Name:      "compile",
+			Usage:     "compile miniscript or a policy to tapscript and a witness template",
+			ArgsUsage: "<expression>",
+			Action:    compile,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "expr",
+					Usage: "miniscript or policy expression to compile",
+				},
+				&cli.BoolFlag{
+					Name:  "policy",
+					Usage: "the expression is a policy to compile to miniscript first",
+				},
+				&cli.StringFlag{
+					Name:  "privkeys",
+					Usage: "specify private keys as \"key1:<hex>,key2:<hex>\" to use for keys named in the expression. Set <hex> empty to generate a random key.",
+				},
+			},
+		},
+		{
+			Name:   "controlblock",
+			Usage:  "decode a control block and verify it against a leaf script and output key",
+			Action: controlBlock,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "controlblock",
+					Usage: "control block in hex",
+				},
+				&cli.StringFlag{
+					Name:  "script",
+					Usage: "filename or leaf script as string",
+				},
+				&cli.StringFlag{
+					Name:  "outputkey",
+					Usage: "x-only taproot output key to verify the control block against",
+				},
+				&cli.StringFlag{
+					Name:  "tx",
+					Usage: "serialized transaction in hex to take the control block and leaf script from",
+				},
+				&cli.StringFlag{
+					Name:  "prevouts",
+					Usage: "serialized prevouts comma seperated, to take the output key from",
+				},
+				&cli.IntFlag{
+					Name:  "inputindex",
+					Usage: "index of input from \"tx\" to inspect",
+				},
+			},
+		},
+		{
+			Name:  "contract",
+			Usage: "simulate a MATT contract",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "run",
+					Usage:     "run the transitions of a contract, chaining the transactions",
+					ArgsUsage: "<contract file>",
+					Action:    contractRun,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "contract",
+							Usage: "JSON file describing the contract states and transitions",
+						},
+						&cli.BoolFlag{
+							Name:  "debug",
+							Usage: "step through the first failing transition",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "ctv",
+			Usage: "compute and check BIP119 template hashes",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "hash",
+					Usage:  "compute the template hash of a transaction, given or built from a script spend",
+					Action: ctvHash,
+					Flags: append(spendFlags, []cli.Flag{
+						&cli.StringFlag{
+							Name:  "tx",
+							Usage: "serialized transaction in hex, instead of building one",
+						},
+						&cli.IntFlag{
+							Name:  "inputindex",
+							Usage: "index of the input to compute the hash for",
+						},
+						&cli.StringFlag{
+							Name:  "expect",
+							Usage: "expected template hash in hex",
+						},
+						&cli.StringFlag{
+							Name:  "expecttx",
+							Usage: "serialized transaction in hex with the expected template, compared field by field",
+						},
+						&cli.IntFlag{
+							Name:  "expectindex",
+							Usage: "input index for \"expecttx\", if different from inputindex",
+						},
+					}...),
+				},
+			},
+		},
+		{
+			Name:   "dap",
+			Usage:  "run a Debug Adapter Protocol server, to debug scripts from editors",
+			Action: dapServer,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "listen",
+					Usage: "TCP address to listen on, like \"127.0.0.1:4711\", instead of using stdin and stdout",
+				},
+			},
+		},
+		{
+			Name:      "address",
+			Usage:     "decode an address to its witness program",
+			ArgsUsage: "<address>",
+			Action:    decodeAddress,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "address",
+					Usage: "address to decode",
+				},
 			},
 		},
 	}
@@ -162,58 +293,33 @@ func execute(cCtx *cli.Context) error {
 
 	skipAhead := cCtx.Int("skip")
 
-	outputKeyStr := cCtx.String("outputkey")
-	outputsStr := cCtx.String("outputs")
-
-	if len(outputKeyStr) > 0 && len(outputsStr) > 0 {
-		return fmt.Errorf("cannot set both outputkey and outputs")
-	}
-
-	if len(outputKeyStr) > 0 {
-		outputsStr = fmt.Sprintf("%s:100000000", outputKeyStr)
-	}
-
-	outputs := strings.Split(outputsStr, ",")
-	var txOutKeys []script.TxOutput
-	for _, oStr := range outputs {
-		if oStr == "" {
-			continue
-		}
+	output.Color = !cCtx.Bool("no-color") && output.ColorSupported()
 
-		k := strings.Split(oStr, ":")
-		pubKeyBytes, err := hex.DecodeString(k[0])
+	var trace io.Writer
+	if traceFile := cCtx.String("trace"); traceFile != "" {
+		f, err := os.Create(traceFile)
 		if err != nil {
 			return err
 		}
+		defer f.Close()
 
-		pubKey, err := schnorr.ParsePubKey(pubKeyBytes)
-		if err != nil {
-			return err
-		}
+		trace = f
+	}
 
-		val, err := strconv.ParseInt(k[1], 10, 0)
+	var report io.Writer
+	if reportFile := cCtx.String("report"); reportFile != "" {
+		f, err := os.Create(reportFile)
 		if err != nil {
 			return err
 		}
+		defer f.Close()
 
-		txOutKeys = append(txOutKeys, script.TxOutput{
-			OutputKey: pubKey,
-			Value:     val,
-		})
+		report = f
 	}
 
-	tagFile := cCtx.String("tagfile")
-	var tags map[string]string
-	if tagFile != "" {
-		tagBytes, err := file.Read(tagFile)
-		if err != nil {
-			return err
-		}
-
-		tags, err = file.ParseTagMap(tagBytes)
-		if err != nil {
-			return err
-		}
+	tags, err := readTags(cCtx.String("tagfile"))
+	if err != nil {
+		return err
 	}
 
 	nonInteractive := cCtx.Bool("non-interactive")
@@ -226,37 +332,20 @@ func execute(cCtx *cli.Context) error {
 		return fmt.Errorf("cannot set both inputkey and prevouts")
 	}
 
-	inputKeyBytes, err := hex.DecodeString(inputKeyStr)
+	prevOuts, err := parsePrevOuts(prevoutsStr)
 	if err != nil {
 		return err
 	}
 
-	var prevOuts []*wire.TxOut
-	for _, p := range strings.Split(prevoutsStr, ",") {
-		if p == "" {
-			prevOuts = append(prevOuts, nil)
-			continue
-		}
-
-		b, err := hex.DecodeString(p)
-		if err != nil {
-			return err
-		}
-
-		txOut := wire.TxOut{}
-		reader := bytes.NewReader(b)
-		err = wire.ReadTxOut(reader, 0, 0, &txOut)
-		if err != nil {
-			return err
-		}
-
-		prevOuts = append(prevOuts, &txOut)
-	}
-
-	var scriptStr []string
 	scriptFile := cCtx.String("script")
 	scriptFiles := cCtx.String("scripts")
+	desc := cCtx.String("descriptor")
 	txStr := cCtx.String("tx")
+	psbtStr := cCtx.String("psbt")
+
+	if psbtStr != "" && prevoutsStr != "" {
+		return fmt.Errorf("cannot set both psbt and prevouts")
+	}
 
 	nn := 0
 	if scriptFile != "" {
@@ -265,138 +354,213 @@ func execute(cCtx *cli.Context) error {
 	if scriptFiles != "" {
 		nn++
 	}
+	if desc != "" {
+		nn++
+	}
 	if txStr != "" {
 		nn++
 	}
+	if psbtStr != "" {
+		nn++
+	}
 	if nn != 1 {
-		return fmt.Errorf("must set single one of script, scripts or tx")
+		return fmt.Errorf("must set single one of script, scripts, " +
+			"descriptor, tx or psbt")
 	}
 
-	scriptIndex := cCtx.Int("scriptindex")
 	inputIndex := cCtx.Int("inputindex")
+	allInputs := cCtx.Bool("all-inputs")
 
-	var tx *wire.MsgTx
-	if scriptFile != "" {
-		// Attempt to read the script from file.
-		scriptBytes, err := file.Read(scriptFile)
-		if err == nil {
-			s, err := file.ParseScript(scriptBytes)
+	if allInputs && txStr == "" && psbtStr == "" {
+		return fmt.Errorf("all-inputs can only be used with tx or psbt")
+	}
+
+	// executeTx steps through the input at inputIndex of the given
+	// transaction. If all inputs should be validated, we first validate
+	// them non-interactively, and only step through the first failing
+	// one.
+	executeTx := func(tx *wire.MsgTx, prevOuts []*wire.TxOut) error {
+		if allInputs {
+			results, err := script.ValidateInputs(tx, prevOuts)
 			if err != nil {
 				return err
 			}
 
-			scriptStr = []string{s}
-		} else {
-			// If we failed reading the file, assume it's the
-			// script directly.
-			scriptStr = []string{scriptFile}
-		}
-	} else if scriptFiles != "" {
-		for _, f := range strings.Split(scriptFiles, ",") {
-			if f == "" {
-				continue
+			failed := -1
+			for _, r := range results {
+				if r.Err == nil {
+					fmt.Printf("input %d: OK\r\n", r.Index)
+					continue
+				}
+
+				fmt.Printf("input %d: FAILED: %s\r\n", r.Index,
+					r.Err)
+				if failed < 0 {
+					failed = r.Index
+				}
 			}
 
-			scriptBytes, err := file.Read(f)
-			if err != nil {
-				return err
-			}
-			s, err := file.ParseScript(scriptBytes)
-			if err != nil {
-				return err
+			if failed < 0 {
+				fmt.Printf("all %d inputs verified\r\n",
+					len(results))
+				return nil
 			}
 
-			scriptStr = append(scriptStr, s)
-		}
-	} else if txStr != "" {
-		b, err := hex.DecodeString(txStr)
-		if err != nil {
-			return err
-		}
-		reader := bytes.NewReader(b)
-		tx = &wire.MsgTx{}
-		err = tx.Deserialize(reader)
-		if err != nil {
-			return err
+			fmt.Printf("stepping through input %d\r\n", failed)
+			inputIndex = failed
 		}
 
 		executeErr := script.ExecuteTx(
 			tx, prevOuts, inputIndex, !nonInteractive,
-			noStep, tags, skipAhead,
+			noStep, tags, skipAhead, trace, report,
 		)
 		if executeErr != nil {
-			fmt.Printf("script exection failed: %s\r\n", executeErr)
+			printFailure(executeErr)
 			return executeErr
 		}
 
 		fmt.Printf("tx execution verified\r\n")
 		return nil
-	} else {
-		return fmt.Errorf("must specify tx or script")
 	}
 
-	var witnessFile, witnessStr string
-	if cCtx.NArg() > 1 {
-		witnessFile = cCtx.Args().Get(1)
-	} else if cCtx.String("witness") != "" {
-		witnessFile = cCtx.String("witness")
-	}
-
-	// Attempt to read the witness from file.
-	witnessBytes, err := file.Read(witnessFile)
-	if err == nil {
-		witnessStr, err = file.ParseScript(witnessBytes)
-		if err != nil {
-			return err
-		}
-	} else {
-		// If we failed reading the file, assume it's the
-		// witness directly.
-		witnessStr = witnessFile
-	}
-
-	privKeys := strings.Split(cCtx.String("privkeys"), ",")
-	keyMap := make(map[string][]byte)
-	for _, privKeyStr := range privKeys {
-		if privKeyStr == "" {
-			continue
-		}
-		k := strings.Split(privKeyStr, ":")
-		privKeyBytes, err := hex.DecodeString(k[1])
+	if txStr != "" {
+		tx, err := parseTx(txStr)
 		if err != nil {
 			return err
 		}
 
-		keyMap[k[0]] = privKeyBytes
-	}
-
-	fmt.Printf("Script: %s\r\n", scriptStr[scriptIndex])
-	fmt.Printf("Witness: %s\r\n", witnessStr)
-
-	var parsedScripts [][]byte
-	for _, s := range scriptStr {
-		parsedScript, err := script.Parse(s)
+		return executeTx(tx, prevOuts)
+	} else if psbtStr != "" {
+		tx, prevOuts, psbtTags, err := readPsbt(psbtStr, tags)
 		if err != nil {
 			return err
 		}
 
-		parsedScripts = append(parsedScripts, parsedScript)
+		tags = psbtTags
+		return executeTx(tx, prevOuts)
 	}
 
-	parsedWitness, err := script.ParseWitness(witnessStr)
+	sp, err := parseSpend(cCtx, 0)
 	if err != nil {
 		return err
 	}
 
+	fmt.Printf("Script: %s\r\n", sp.scriptStr[sp.scriptIndex])
+	fmt.Printf("Witness: %s\r\n", sp.witnessStr)
+
 	executeErr := script.Execute(
-		keyMap, inputKeyBytes, txOutKeys, parsedScripts, scriptIndex,
-		parsedWitness, !nonInteractive, noStep, tags, skipAhead,
+		sp.options(), !nonInteractive, noStep, tags, skipAhead, trace,
+		report,
 	)
 	if executeErr != nil {
-		fmt.Printf("script exection failed: %s\r\n", executeErr)
+		printFailure(executeErr)
 		return executeErr
 	}
 
 	fmt.Printf("script execution verified\r\n")
 	return nil
 }
+
+// readTags reads the tag file, if set.
+func readTags(tagFile string) (map[string]string, error) {
+	if tagFile == "" {
+		return nil, nil
+	}
+
+	tagBytes, err := file.Read(tagFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return file.ParseTagMap(tagBytes)
+}
+
+// readPsbt reads the PSBT from file, or parses it directly, and returns the
+// transaction and prevouts it describes. The tags from the PSBT are returned
+// added to the given tags, which take precedence.
+func readPsbt(psbtStr string, tags map[string]string) (*wire.MsgTx,
+	[]*wire.TxOut, map[string]string, error) {
+
+	// Attempt to read the PSBT from file.
+	psbtBytes, err := file.Read(psbtStr)
+	if err != nil {
+		// If we failed reading the file, assume it's the PSBT
+		// directly.
+		psbtBytes = []byte(psbtStr)
+	}
+
+	packet, err := file.ParsePsbt(psbtBytes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	tx, prevOuts, psbtTags, err := script.TxFromPsbt(packet)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	merged := make(map[string]string)
+	for k, v := range psbtTags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+
+	return tx, prevOuts, merged, nil
+}
+
+// parsePrevOuts parses the comma separated list of serialized prevouts.
+// Empty entries are parsed as nil prevouts.
+func parsePrevOuts(prevoutsStr string) ([]*wire.TxOut, error) {
+	var prevOuts []*wire.TxOut
+	for _, p := range strings.Split(prevoutsStr, ",") {
+		if p == "" {
+			prevOuts = append(prevOuts, nil)
+			continue
+		}
+
+		b, err := hex.DecodeString(p)
+		if err != nil {
+			return nil, err
+		}
+
+		txOut := wire.TxOut{}
+		reader := bytes.NewReader(b)
+		err = wire.ReadTxOut(reader, 0, 0, &txOut)
+		if err != nil {
+			return nil, err
+		}
+
+		prevOuts = append(prevOuts, &txOut)
+	}
+
+	return prevOuts, nil
+}
+
+// parseTx parses the hex serialized transaction.
+func parseTx(txStr string) (*wire.MsgTx, error) {
+	b, err := hex.DecodeString(txStr)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// printFailure prints a report of the failed execution. If the VM failed
+// during execution, the report describes the failing opcode and VM state.
+func printFailure(executeErr error) {
+	var failure *script.Failure
+	if !errors.As(executeErr, &failure) {
+		fmt.Printf("script exection failed: %s\r\n", executeErr)
+		return
+	}
+
+	output.DrawTable(strings.TrimSuffix(failure.String(), "\n"), 0)
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/halseth/tapsim/address"
+	"github.com/halseth/tapsim/descriptor"
+	"github.com/halseth/tapsim/file"
+	"github.com/halseth/tapsim/keyring"
+	"github.com/halseth/tapsim/script"
+	"github.com/halseth/tapsim/tweak"
+	"github.com/urfave/cli/v2"
+)
+
+// spendFlags are the flags describing a script spend, shared by the commands
+// building a transaction from a script and witness.
+var spendFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "script",
+		Usage: "filename or output script as string",
+	},
+	&cli.StringFlag{
+		Name:  "scripts",
+		Usage: "list of filenames with output scripts to assemble into a taptree",
+	},
+	&cli.StringFlag{
+		Name:  "descriptor",
+		Usage: "tr() descriptor of the output to spend, instead of scripts and inputkey. Keys can have [fingerprint/path] origins, and be xpubs with unhardened derivation paths",
+	},
+	&cli.UintFlag{
+		Name:  "descriptorindex",
+		Usage: "index to derive * in the derivation paths of \"descriptor\" keys at",
+	},
+	&cli.IntFlag{
+		Name:  "scriptindex",
+		Usage: "index of script from \"scripts\" or \"descriptor\" to execute",
+	},
+	&cli.StringFlag{
+		Name:  "leafhash",
+		Usage: "tap leaf hash of the script from \"scripts\" or \"descriptor\" to execute, instead of scriptindex",
+	},
+	&cli.StringFlag{
+		Name:  "type",
+		Usage: "type of output committing to the script: p2tr, p2wsh, p2sh, p2sh-p2wsh or legacy",
+		Value: "p2tr",
+	},
+	&cli.StringFlag{
+		Name:  "witness",
+		Usage: "filename or witness stack as string",
+	},
+	&cli.StringFlag{
+		Name:  "privkeys",
+		Usage: "specify private keys as \"key1:<hex>,key2:<hex>\" to sign the transaction. Set <hex> empty to derive the key from the seed or keyring, or generate a random key if neither is set.",
+	},
+	&cli.StringFlag{
+		Name:  "seed",
+		Usage: "hex seed to deterministically derive named keys from, instead of generating random keys",
+	},
+	&cli.StringFlag{
+		Name:  "keyring",
+		Usage: "keyring file with the seed and named keys, instead of seed",
+	},
+	&cli.StringFlag{
+		Name:  "inputkey",
+		Usage: "use specified internal key for the input, or \"nums\" for the BIP341 NUMS point",
+	},
+	&cli.StringFlag{
+		Name:  "inputdata",
+		Usage: "hex data embedded in the inputkey, before committing to the taptree",
+	},
+	&cli.StringFlag{
+		Name:  "outputkey",
+		Usage: "use specified internal key for the output",
+	},
+	&cli.StringFlag{
+		Name:  "outputdata",
+		Usage: "hex data to embed in the outputkey, which then commits to the same taptree as the input",
+	},
+	&cli.StringFlag{
+		Name:  "outputs",
+		Usage: "specify outputs as \"<pubkey>:<value>\", \"tr:<key>:<script1>+<script2>:<data>:<value>\", \"p2wpkh:<pubkey>:<value>\" or \"opreturn:<data>\"",
+	},
+	&cli.StringFlag{
+		Name:  "network",
+		Usage: "network to print addresses for: mainnet, testnet, signet or regtest",
+		Value: "mainnet",
+	},
+}
+
+// spend holds the parsed flags describing a script spend.
+type spend struct {
+	scriptStr   []string
+	witnessStr  string
+	privKeys    map[string][]byte
+	inputKey    []byte
+	inputData   []byte
+	outputs     []script.TxOutput
+	scripts     [][]byte
+	tapTree     *txscript.IndexedTapScriptTree
+	scriptIndex int
+	scriptType  script.ScriptType
+	witness     []script.WitnessGen
+	chainParams *chaincfg.Params
+}
+
+// options returns the options to build the spend with script.Build.
+func (sp *spend) options() *script.Options {
+	return &script.Options{
+		Scripts:     sp.scripts,
+		TapTree:     sp.tapTree,
+		ScriptIndex: sp.scriptIndex,
+		ScriptType:  sp.scriptType,
+		Witness:     sp.witness,
+		PrivKeys:    sp.privKeys,
+		InputKey:    sp.inputKey,
+		InputData:   sp.inputData,
+		Outputs:     sp.outputs,
+		ChainParams: sp.chainParams,
+	}
+}
+
+// parseSpend parses the spendFlags. Outputs created by default spend the
+// input value minus the given fee.
+func parseSpend(cCtx *cli.Context, fee int64) (*spend, error) {
+	var (
+		scriptStr     []string
+		parsedScripts [][]byte
+		tapTree       *txscript.IndexedTapScriptTree
+		inputKeyBytes []byte
+	)
+	if desc := cCtx.String("descriptor"); desc != "" {
+		if cCtx.String("script") != "" || cCtx.String("scripts") != "" ||
+			cCtx.String("inputkey") != "" {
+
+			return nil, fmt.Errorf("cannot set descriptor together " +
+				"with script, scripts or inputkey")
+		}
+
+		tr, err := descriptor.ParseTaproot(
+			desc, uint32(cCtx.Uint("descriptorindex")),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if tr.Tree == nil {
+			return nil, fmt.Errorf("descriptor has no script tree")
+		}
+
+		scriptStr = tr.Scripts
+		tapTree = tr.Tree
+		inputKeyBytes = schnorr.SerializePubKey(tr.InternalKey)
+		for _, p := range tr.Tree.LeafMerkleProofs {
+			parsedScripts = append(parsedScripts, p.Script)
+		}
+	} else {
+		var err error
+		scriptStr, err = readScripts(cCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		inputKeyBytes, err = parseKey(cCtx.String("inputkey"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range scriptStr {
+			parsedScript, err := script.Parse(s)
+			if err != nil {
+				return nil, err
+			}
+
+			parsedScripts = append(parsedScripts, parsedScript)
+		}
+	}
+
+	inputData, err := hex.DecodeString(cCtx.String("inputdata"))
+	if err != nil {
+		return nil, err
+	}
+
+	scriptIndex := cCtx.Int("scriptindex")
+	if leafHash := cCtx.String("leafhash"); leafHash != "" {
+		if cCtx.IsSet("scriptindex") {
+			return nil, fmt.Errorf("cannot set both scriptindex " +
+				"and leafhash")
+		}
+
+		scriptIndex, err = leafIndex(parsedScripts, leafHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if scriptIndex < 0 || scriptIndex >= len(scriptStr) {
+		return nil, fmt.Errorf("script index %d out of range",
+			scriptIndex)
+	}
+
+	witnessStr, err := readWitness(cCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	kr, err := keyring.Open(cCtx.String("keyring"), cCtx.String("seed"))
+	if err != nil {
+		return nil, err
+	}
+
+	keyMap, err := parsePrivKeys(cCtx, kr, witnessStr)
+	if err != nil {
+		return nil, err
+	}
+
+	txOutKeys, err := parseOutputs(cCtx, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	// With a seed, the keys otherwise generated at random are derived
+	// from it, such that every run gives the same transaction.
+	if kr != nil && kr.HasSeed() {
+		if len(inputKeyBytes) == 0 {
+			privKey, err := kr.Key("inputkey")
+			if err != nil {
+				return nil, err
+			}
+
+			inputKeyBytes = schnorr.SerializePubKey(privKey.PubKey())
+		}
+
+		if len(txOutKeys) == 0 {
+			privKey, err := kr.Key("outputkey")
+			if err != nil {
+				return nil, err
+			}
+
+			txOutKeys = append(txOutKeys, script.TxOutput{
+				OutputKey: privKey.PubKey(),
+				Value:     defaultOutputValue(cCtx, fee),
+			})
+		}
+	}
+
+	parsedWitness, err := script.ParseWitness(witnessStr)
+	if err != nil {
+		return nil, err
+	}
+
+	scriptType, err := script.ParseScriptType(cCtx.String("type"))
+	if err != nil {
+		return nil, err
+	}
+
+	chainParams, err := address.ParseNetwork(cCtx.String("network"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &spend{
+		scriptStr:   scriptStr,
+		witnessStr:  witnessStr,
+		privKeys:    keyMap,
+		inputKey:    inputKeyBytes,
+		inputData:   inputData,
+		outputs:     txOutKeys,
+		scripts:     parsedScripts,
+		tapTree:     tapTree,
+		scriptIndex: scriptIndex,
+		scriptType:  scriptType,
+		witness:     parsedWitness,
+		chainParams: chainParams,
+	}, nil
+}
+
+// parseKey parses the hex encoded x-only key, where "nums" is the BIP341 NUMS
+// point. An empty string gives an empty key.
+func parseKey(keyStr string) ([]byte, error) {
+	if keyStr == "nums" {
+		return txscript.BIP341_NUMS_POINT, nil
+	}
+
+	return hex.DecodeString(keyStr)
+}
+
+// leafIndex returns the index of the script with the given tap leaf hash.
+func leafIndex(scripts [][]byte, leafHash string) (int, error) {
+	h, err := hex.DecodeString(leafHash)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, s := range scripts {
+		tapHash := txscript.NewBaseTapLeaf(s).TapHash()
+		if bytes.Equal(tapHash[:], h) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no script with leaf hash %s", leafHash)
+}
+
+// parseOutputs parses the taproot outputs given by the outputkey or outputs
+// flags. An output given only by outputkey spends the input value minus fee.
+func parseOutputs(cCtx *cli.Context, fee int64) ([]script.TxOutput, error) {
+	outputKeyStr := cCtx.String("outputkey")
+	outputsStr := cCtx.String("outputs")
+
+	if len(outputKeyStr) > 0 && len(outputsStr) > 0 {
+		return nil, fmt.Errorf("cannot set both outputkey and outputs")
+	}
+
+	if outputDataStr := cCtx.String("outputdata"); outputDataStr != "" {
+		if len(outputKeyStr) == 0 {
+			return nil, fmt.Errorf("outputdata requires outputkey")
+		}
+
+		return dataOutput(
+			outputKeyStr, outputDataStr, defaultOutputValue(cCtx, fee),
+		)
+	}
+
+	if len(outputKeyStr) > 0 {
+		outputsStr = fmt.Sprintf("%s:%d", outputKeyStr,
+			defaultOutputValue(cCtx, fee))
+	}
+
+	var txOutputs []script.TxOutput
+	for _, oStr := range strings.Split(outputsStr, ",") {
+		if oStr == "" {
+			continue
+		}
+
+		o, err := parseOutput(oStr)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %w", oStr, err)
+		}
+
+		txOutputs = append(txOutputs, *o)
+	}
+
+	return txOutputs, nil
+}
+
+// parseOutput parses a single output spec. It can be one of
+//   - <pubkey>:<value> for a taproot output to the output key
+//   - tr:<key>:<scripts>:<data>:<value> for a taproot output with internal
+//     key <key> or nums, committing to the taptree assembled from the "+"
+//     separated script files <scripts>, with <data> embedded. <scripts> can
+//     be empty for no taptree, or "input" to use the taptree of the input.
+//   - p2wpkh:<pubkey>:<value> for a P2WPKH output to the compressed key
+//   - opreturn:<data> for an OP_RETURN output carrying the data
+func parseOutput(oStr string) (*script.TxOutput, error) {
+	k := strings.Split(oStr, ":")
+
+	parseValue := func(v string) (int64, error) {
+		return strconv.ParseInt(v, 10, 64)
+	}
+
+	switch k[0] {
+	case "tr":
+		if len(k) != 5 {
+			return nil, fmt.Errorf("expected " +
+				"tr:<key>:<scripts>:<data>:<value>")
+		}
+
+		key, err := tweak.ParseKey(k[1])
+		if err != nil {
+			return nil, err
+		}
+
+		o := &script.TxOutput{InternalKey: key}
+		switch k[2] {
+		case "":
+		case "input":
+			o.InputTapTree = true
+		default:
+			o.TapTree, err = readTapTree(strings.Split(k[2], "+"))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		o.Data, err = hex.DecodeString(k[3])
+		if err != nil {
+			return nil, err
+		}
+
+		o.Value, err = parseValue(k[4])
+		if err != nil {
+			return nil, err
+		}
+
+		return o, nil
+
+	case "p2wpkh":
+		if len(k) != 3 {
+			return nil, fmt.Errorf("expected p2wpkh:<pubkey>:<value>")
+		}
+
+		pubKey, err := hex.DecodeString(k[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := btcec.ParsePubKey(pubKey); err != nil {
+			return nil, err
+		}
+
+		if len(pubKey) != btcec.PubKeyBytesLenCompressed {
+			return nil, fmt.Errorf("p2wpkh key must be compressed")
+		}
+
+		pkScript, err := script.P2WPKHScript(pubKey)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := parseValue(k[2])
+		if err != nil {
+			return nil, err
+		}
+
+		return &script.TxOutput{PkScript: pkScript, Value: value}, nil
+
+	case "opreturn":
+		if len(k) != 2 {
+			return nil, fmt.Errorf("expected opreturn:<data>")
+		}
+
+		data, err := hex.DecodeString(k[1])
+		if err != nil {
+			return nil, err
+		}
+
+		pkScript, err := script.OpReturnScript(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return &script.TxOutput{PkScript: pkScript}, nil
+	}
+
+	if len(k) != 2 {
+		return nil, fmt.Errorf("expected <pubkey>:<value>")
+	}
+
+	pubKeyBytes, err := hex.DecodeString(k[0])
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := schnorr.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseValue(k[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &script.TxOutput{
+		OutputKey: pubKey,
+		Value:     value,
+	}, nil
+}
+
+// readTapTree assembles the scripts read from the given files into a
+// taptree. If a file cannot be read, it is assumed to be the script itself.
+func readTapTree(files []string) (*txscript.IndexedTapScriptTree, error) {
+	var tapLeaves []txscript.TapLeaf
+	for _, f := range files {
+		scriptStr := f
+		scriptBytes, err := file.Read(f)
+		if err == nil {
+			scriptStr, err = file.ParseScript(scriptBytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		pkScript, err := script.Parse(scriptStr)
+		if err != nil {
+			return nil, err
+		}
+
+		tapLeaves = append(tapLeaves, txscript.NewBaseTapLeaf(pkScript))
+	}
+
+	return txscript.AssembleTaprootScriptTree(tapLeaves...), nil
+}
+
+// dataOutput returns an output embedding the data in the internal key.
+func dataOutput(keyStr, dataStr string, value int64) ([]script.TxOutput,
+	error) {
+
+	key, err := tweak.ParseKey(keyStr)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(dataStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return []script.TxOutput{{
+		InternalKey:  key,
+		Data:         data,
+		InputTapTree: true,
+		Value:        value,
+	}}, nil
+}
+
+// defaultOutputValue returns the value of an output created by default,
+// spending the input value minus the fee. The input value is 1e8 sats for
+// commands without the inputvalue flag.
+func defaultOutputValue(cCtx *cli.Context, fee int64) int64 {
+	inputValue := cCtx.Int64("inputvalue")
+	if inputValue == 0 {
+		inputValue = 1e8
+	}
+
+	return inputValue - fee
+}
+
+// readScripts reads the scripts given by the script or scripts flags. The
+// script flag can be either a filename or the script itself.
+func readScripts(cCtx *cli.Context) ([]string, error) {
+	scriptFile := cCtx.String("script")
+	scriptFiles := cCtx.String("scripts")
+
+	var scriptStr []string
+	if scriptFile != "" {
+		// Attempt to read the script from file.
+		scriptBytes, err := file.Read(scriptFile)
+		if err == nil {
+			s, err := file.ParseScript(scriptBytes)
+			if err != nil {
+				return nil, err
+			}
+
+			scriptStr = []string{s}
+		} else {
+			// If we failed reading the file, assume it's the
+			// script directly.
+			scriptStr = []string{scriptFile}
+		}
+	} else if scriptFiles != "" {
+		for _, f := range strings.Split(scriptFiles, ",") {
+			if f == "" {
+				continue
+			}
+
+			scriptBytes, err := file.Read(f)
+			if err != nil {
+				return nil, err
+			}
+			s, err := file.ParseScript(scriptBytes)
+			if err != nil {
+				return nil, err
+			}
+
+			scriptStr = append(scriptStr, s)
+		}
+	}
+
+	if len(scriptStr) == 0 {
+		return nil, fmt.Errorf("must set script, scripts or " +
+			"descriptor")
+	}
+
+	return scriptStr, nil
+}
+
+// readWitness reads the witness given as the second argument or by the
+// witness flag. It can be either a filename or the witness itself.
+func readWitness(cCtx *cli.Context) (string, error) {
+	var witnessFile, witnessStr string
+	if cCtx.NArg() > 1 {
+		witnessFile = cCtx.Args().Get(1)
+	} else if cCtx.String("witness") != "" {
+		witnessFile = cCtx.String("witness")
+	}
+
+	// Attempt to read the witness from file.
+	witnessBytes, err := file.Read(witnessFile)
+	if err == nil {
+		witnessStr, err = file.ParseScript(witnessBytes)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		// If we failed reading the file, assume it's the
+		// witness directly.
+		witnessStr = witnessFile
+	}
+
+	return witnessStr, nil
+}
+
+// parsePrivKeys parses the private keys given by the privkeys flag into a map
+// from key name to key bytes. If a keyring is given, all its keys are added,
+// and keys with an empty value or used for signatures in the witness are
+// derived from it.
+func parsePrivKeys(cCtx *cli.Context, kr *keyring.Keyring,
+	witnessStr string) (map[string][]byte, error) {
+
+	keyMap := make(map[string][]byte)
+	if kr != nil {
+		for _, name := range kr.Names() {
+			privKey, err := kr.Key(name)
+			if err != nil {
+				return nil, err
+			}
+
+			keyMap[name] = privKey.Serialize()
+		}
+	}
+
+	privKeys := strings.Split(cCtx.String("privkeys"), ",")
+	for _, privKeyStr := range privKeys {
+		if privKeyStr == "" {
+			continue
+		}
+		k := strings.Split(privKeyStr, ":")
+		privKeyBytes, err := hex.DecodeString(k[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if len(privKeyBytes) == 0 && kr != nil {
+			privKey, err := kr.Key(k[0])
+			if err != nil {
+				return nil, err
+			}
+
+			privKeyBytes = privKey.Serialize()
+		}
+
+		keyMap[k[0]] = privKeyBytes
+	}
+
+	if kr == nil || !kr.HasSeed() {
+		return keyMap, nil
+	}
+
+	for _, name := range sigKeys(witnessStr) {
+		if _, ok := keyMap[name]; ok {
+			continue
+		}
+
+		privKey, err := kr.Key(name)
+		if err != nil {
+			return nil, err
+		}
+
+		keyMap[name] = privKey.Serialize()
+	}
+
+	return keyMap, nil
+}
+
+// sigKeys returns the names of the keys used for <sig:NAME> elements in the
+// witness.
+func sigKeys(witnessStr string) []string {
+	var names []string
+	for _, o := range strings.Split(witnessStr, " ") {
+		if !strings.HasPrefix(o, "<sig:") || !strings.HasSuffix(o, ">") {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(o, "<sig:"), ">")
+		names = append(names, name)
+	}
+
+	return names
+}
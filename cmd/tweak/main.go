@@ -6,17 +6,25 @@ import (
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/halseth/tapsim/address"
+	"github.com/halseth/tapsim/descriptor"
 	"github.com/halseth/tapsim/file"
+	"github.com/halseth/tapsim/keyring"
 	"github.com/halseth/tapsim/script"
+	"github.com/halseth/tapsim/tweak"
 	flags "github.com/jessevdk/go-flags"
 )
 
 type config struct {
-	Key     string `short:"k" long:"key" description:"key to use (random if empty)"`
+	Key     string `short:"k" long:"key" description:"key to use, or name of a key in the keyring (random if empty, or derived from the seed)"`
+	Seed    string `long:"seed" description:"hex seed to derive the key from"`
+	Keyring string `long:"keyring" description:"keyring file to derive the key from, instead of seed"`
 	Script  string `long:"script" description:"script or script file"`
 	Taproot string `long:"taproot" description:"taptree root hash"`
-	Merkle  string `long:"merkle" description:"merkle commitment"`
+	Merkle  string `long:"merkle" description:"merkle commitment. If empty, the key is still tweaked with the empty commitment, unlike an empty --inputdata in tapsim"`
+	Network string `long:"network" description:"network to print addresses for: mainnet, testnet, signet or regtest" default:"mainnet"`
 }
 
 var cfg = config{}
@@ -39,6 +47,11 @@ func run() error {
 		return fmt.Errorf("cannot use both script and taproot")
 	}
 
+	net, err := address.ParseNetwork(cfg.Network)
+	if err != nil {
+		return err
+	}
+
 	var scriptStr string
 	scriptBytes, err := file.Read(cfg.Script)
 	if err == nil {
@@ -80,51 +93,116 @@ func run() error {
 		}
 	}
 
-	// Random key.
-	var keyBytes []byte
-	if cfg.Key == "" {
-		privKey, err := btcec.NewPrivateKey()
-		if err != nil {
-			return err
-		}
-		pubKey := privKey.PubKey()
-		keyBytes = schnorr.SerializePubKey(pubKey)
-	} else if cfg.Key == "nums" {
-		keyBytes = txscript.BIP341_NUMS_POINT
-	} else {
-		var err error
-		keyBytes, err = hex.DecodeString(cfg.Key)
-		if err != nil {
-			return err
-		}
-
-	}
-	fmt.Println("inner internal key:", hex.EncodeToString(keyBytes))
-	fmt.Println("taproot:", hex.EncodeToString(tapScriptRootHash[:]))
-	fmt.Println("merkle root:", hex.EncodeToString(merkleBytes[:]))
-
-	pubKey, err := schnorr.ParsePubKey(keyBytes)
+	pubKey, err := parseKey()
 	if err != nil {
 		return err
 	}
+	fmt.Println("inner internal key:", hex.EncodeToString(schnorr.SerializePubKey(pubKey)))
+	fmt.Println("taproot:", hex.EncodeToString(tapScriptRootHash[:]))
+	fmt.Println("merkle root:", hex.EncodeToString(merkleBytes[:]))
 
-	// Tweak pubkey with data.
-	tweaked := txscript.SingleTweakPubKey(pubKey, merkleBytes[:])
+	// Tweak pubkey with data, then with the taptree. Unlike
+	// tweak.ComputeRoot, the key is tweaked even if the merkle commitment
+	// is empty.
+	tweaked := txscript.SingleTweakPubKey(pubKey, merkleBytes)
 	tweakedBytes := schnorr.SerializePubKey(tweaked)
 	fmt.Println("tweaked(merkle):", hex.EncodeToString(tweakedBytes))
 
-	tweaked2 := txscript.ComputeTaprootOutputKey(tweaked, tapScriptRootHash[:])
+	tweaked2 := txscript.ComputeTaprootOutputKey(tweaked, tapScriptRootHash)
 	tweakedBytes2 := schnorr.SerializePubKey(tweaked2)
 	fmt.Println("taproot output key(merkle+taproot):", hex.EncodeToString(tweakedBytes2))
+	if err := printAddress(tweaked2, net); err != nil {
+		return err
+	}
 
 	empty := []byte{}
 	merkleOut := txscript.SingleTweakPubKey(tweaked, empty)
 	merkleOutBytes := schnorr.SerializePubKey(merkleOut)
 	fmt.Println("taproot output key(merkle), no script:", hex.EncodeToString(merkleOutBytes))
+	if err := printAddress(merkleOut, net); err != nil {
+		return err
+	}
 
 	emptyOut := txscript.ComputeTaprootOutputKey(pubKey, empty)
 	emptyOutBytes := schnorr.SerializePubKey(emptyOut)
 	fmt.Println("taproot output key, no tweak no script:", hex.EncodeToString(emptyOutBytes))
 
+	// Without any tweak, the output is a regular key spend output we
+	// can describe using the internal key.
+	desc, _, err := descriptor.Taproot(pubKey, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Println("  descriptor:", desc)
+
+	addr, err := address.Taproot(emptyOut, net)
+	if err != nil {
+		return err
+	}
+	fmt.Println("  address:", addr)
+
+	return nil
+}
+
+// parseKey returns the key to tweak. Keys named in the keyring are looked up,
+// and an empty key is derived from the seed as "key", or random without one.
+func parseKey() (*btcec.PublicKey, error) {
+	kr, err := keyring.Open(cfg.Keyring, cfg.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	name := cfg.Key
+	if name == "" {
+		name = "key"
+	}
+
+	switch {
+	case kr != nil && (cfg.Key == "" || isName(kr, cfg.Key)):
+		privKey, err := kr.Key(name)
+		if err != nil {
+			return nil, err
+		}
+		return privKey.PubKey(), nil
+
+	// Random key.
+	case cfg.Key == "":
+		privKey, err := btcec.NewPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		return privKey.PubKey(), nil
+	}
+
+	return tweak.ParseKey(cfg.Key)
+}
+
+// isName returns whether the key is named in the keyring.
+func isName(kr *keyring.Keyring, key string) bool {
+	for _, name := range kr.Names() {
+		if name == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// printAddress prints the rawtr() descriptor and address of the taproot output
+// key.
+func printAddress(outputKey *btcec.PublicKey, net *chaincfg.Params) error {
+	desc, err := descriptor.RawTaproot(outputKey)
+	if err != nil {
+		return err
+	}
+
+	addr, err := address.Taproot(outputKey, net)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("  descriptor:", desc)
+	fmt.Println("  address:", addr)
+
 	return nil
 }
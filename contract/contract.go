@@ -0,0 +1,234 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/halseth/tapsim/file"
+	"github.com/halseth/tapsim/script"
+	"github.com/halseth/tapsim/tweak"
+)
+
+// Contract describes a MATT contract as a state machine. Every state is a
+// taproot output with a fixed internal key and taptree, differing only in the
+// data embedded in the internal key. Transitions spend a contract output
+// using one of the leaves of its state.
+type Contract struct {
+	// States are the states of the contract.
+	States []State `json:"states"`
+
+	// Start is the output the contract starts in.
+	Start Output `json:"start"`
+
+	// Transitions are the transitions to run in order. Each transition
+	// spends an output of the previous one.
+	Transitions []Transition `json:"transitions"`
+
+	// PrivKeys maps names of private keys used for <sig:NAME> witness
+	// elements to hex encoded keys. An empty key generates a random one.
+	PrivKeys map[string]string `json:"privkeys"`
+}
+
+// State is a state of the contract.
+type State struct {
+	// Name is the name of the state.
+	Name string `json:"name"`
+
+	// InternalKey is the hex encoded x-only internal key of the state
+	// before embedding data, or "nums" or empty for the BIP341 NUMS
+	// point.
+	InternalKey string `json:"internalkey"`
+
+	// Leaves are the tapscript leaves of the state, assembled into a
+	// taptree in the given order.
+	Leaves []Leaf `json:"leaves"`
+}
+
+// Leaf is a tapscript leaf of a state.
+type Leaf struct {
+	// Name is the name of the leaf, used to select it in transitions.
+	Name string `json:"name"`
+
+	// Script is a filename or the leaf script as string. Filenames are
+	// relative to the contract file.
+	Script string `json:"script"`
+}
+
+// Output is an output created by a transition. It is either a contract
+// output in the given state, or a plain taproot output to the given key.
+type Output struct {
+	// State is the name of the state of a contract output.
+	State string `json:"state,omitempty"`
+
+	// Data is the hex encoded data embedded in a contract output.
+	Data string `json:"data,omitempty"`
+
+	// Key is the x-only output key of a plain taproot output.
+	Key string `json:"key,omitempty"`
+
+	// Value is the value of the output in sats. If zero for the only
+	// output of a transition, the value of the spent output minus the fee
+	// of the transition is used.
+	Value int64 `json:"value,omitempty"`
+}
+
+// Transition spends a contract output.
+type Transition struct {
+	// Name is an optional name used when reporting the transition.
+	Name string `json:"name,omitempty"`
+
+	// Input is the index of the output of the previous transition to
+	// spend. The first transition spends the start output.
+	Input int `json:"input"`
+
+	// Leaf is the name of the leaf to spend the output with.
+	Leaf string `json:"leaf"`
+
+	// Witness is a filename or the witness stack as string, not
+	// including the leaf script and control block.
+	Witness string `json:"witness"`
+
+	// Outputs are the outputs of the transaction.
+	Outputs []Output `json:"outputs"`
+
+	// Fee is the fee in sats paid from the spent output when the value
+	// of the only output is not given. If not set, script.DefaultFee is
+	// used. Transitions whose script requires the amount to be kept must
+	// set it to zero, leaving the fee to be paid by another input.
+	Fee *int64 `json:"fee,omitempty"`
+}
+
+// Load reads and parses a contract description from the given JSON file.
+// Script and witness filenames in the contract are resolved relative to the
+// directory of the file.
+func Load(filename string) (*Contract, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Contract{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+
+	dir := filepath.Dir(filename)
+	for i := range c.States {
+		for j := range c.States[i].Leaves {
+			l := &c.States[i].Leaves[j]
+			l.Script = resolve(dir, l.Script)
+		}
+	}
+	for i := range c.Transitions {
+		t := &c.Transitions[i]
+		t.Witness = resolve(dir, t.Witness)
+	}
+
+	return c, nil
+}
+
+// resolve returns the contents of the file relative to dir parsed as a
+// script, or the string itself if no such file exists.
+func resolve(dir, s string) string {
+	if s == "" {
+		return s
+	}
+
+	name := s
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+
+	data, err := file.Read(name)
+	if err != nil {
+		return s
+	}
+
+	parsed, err := file.ParseScript(data)
+	if err != nil {
+		return s
+	}
+
+	return parsed
+}
+
+// State returns the state with the given name.
+func (c *Contract) State(name string) (*State, error) {
+	for i := range c.States {
+		if c.States[i].Name == name {
+			return &c.States[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown state %q", name)
+}
+
+// Key returns the internal key of the state before embedding data.
+func (s *State) Key() (*btcec.PublicKey, error) {
+	return tweak.ParseKey(s.InternalKey)
+}
+
+// Scripts returns the parsed leaf scripts of the state.
+func (s *State) Scripts() ([][]byte, error) {
+	var scripts [][]byte
+	for _, l := range s.Leaves {
+		parsed, err := script.Parse(l.Script)
+		if err != nil {
+			return nil, fmt.Errorf("state %s leaf %s: %w", s.Name,
+				l.Name, err)
+		}
+
+		scripts = append(scripts, parsed)
+	}
+
+	if len(scripts) == 0 {
+		return nil, fmt.Errorf("state %s has no leaves", s.Name)
+	}
+
+	return scripts, nil
+}
+
+// LeafIndex returns the index of the leaf with the given name.
+func (s *State) LeafIndex(name string) (int, error) {
+	for i, l := range s.Leaves {
+		if l.Name == name {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("state %s has no leaf %q", s.Name, name)
+}
+
+// TapTree returns the taptree of the state.
+func (s *State) TapTree() (*txscript.IndexedTapScriptTree, error) {
+	scripts, err := s.Scripts()
+	if err != nil {
+		return nil, err
+	}
+
+	var tapLeaves []txscript.TapLeaf
+	for _, s := range scripts {
+		tapLeaves = append(tapLeaves, txscript.NewBaseTapLeaf(s))
+	}
+
+	return txscript.AssembleTaprootScriptTree(tapLeaves...), nil
+}
+
+// Keys returns the keys of the state with the data embedded.
+func (s *State) Keys(data []byte) (*tweak.Keys, error) {
+	key, err := s.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := s.TapTree()
+	if err != nil {
+		return nil, err
+	}
+
+	return tweak.Compute(key, data, tree), nil
+}
@@ -0,0 +1,376 @@
+package contract
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/halseth/tapsim/script"
+	"github.com/halseth/tapsim/tweak"
+)
+
+// startValue is the value of the start output if not given.
+const startValue = 1e8
+
+// fee returns the fee paid from the spent output when the output value is
+// not given.
+func (t *Transition) fee() int64 {
+	if t.Fee == nil {
+		return script.DefaultFee
+	}
+
+	return *t.Fee
+}
+
+// FeeError is the error of a transition that only failed because its output
+// paid the default fee, like when OP_CHECKCONTRACTVERIFY requires the amount
+// to be kept.
+type FeeError struct {
+	// Err is the error the transition failed with.
+	Err error
+
+	// Fee is the default fee paid from the spent output.
+	Fee int64
+}
+
+// Error returns the error, with a hint to set the fee of the transition.
+func (e *FeeError) Error() string {
+	return fmt.Sprintf("%v: the output paid the default fee of %d sats, "+
+		"set \"fee\": 0 on the transition to keep the amount", e.Err,
+		e.Fee)
+}
+
+// Unwrap returns the error the transition failed with.
+func (e *FeeError) Unwrap() error {
+	return e.Err
+}
+
+// OutputState is an output created by the contract.
+type OutputState struct {
+	// Output is the description of the output.
+	Output
+
+	// OutPoint is the outpoint of the output.
+	OutPoint wire.OutPoint
+
+	// TxOut is the output itself.
+	TxOut *wire.TxOut
+
+	// Keys are the keys of a contract output, with the data embedded.
+	Keys *tweak.Keys
+
+	// OutputKey is the taproot output key.
+	OutputKey *btcec.PublicKey
+}
+
+// Result is the result of running a single transition.
+type Result struct {
+	// Transition is the transition that was run.
+	Transition Transition
+
+	// Tx is the transaction spending the contract output, and PrevOuts
+	// the output it spends.
+	Tx       *wire.MsgTx
+	PrevOuts []*wire.TxOut
+
+	// Outputs are the outputs of the transaction.
+	Outputs []*OutputState
+
+	// Err is the error from script execution, or nil if the transition
+	// is valid.
+	Err error
+}
+
+// StartOutput returns the output the contract starts in. It is spent from a
+// dummy outpoint.
+func (c *Contract) StartOutput() (*OutputState, error) {
+	start := c.Start
+	if start.Value == 0 {
+		start.Value = startValue
+	}
+
+	o, err := c.output(start)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.State == "" {
+		return nil, fmt.Errorf("start output must be a contract state")
+	}
+
+	return o, nil
+}
+
+// Run runs the transitions in order, each spending an output of the
+// previous transaction. Every transaction is validated, including the
+// OP_CHECKCONTRACTVERIFY checks of its script, and running stops at the
+// first invalid transition. The results of the transitions run are
+// returned. An error is only returned if the contract is malformed.
+//
+// If report is non-nil, it is called with the result of each transition as
+// soon as it has been run.
+func (c *Contract) Run(report func(int, *Result)) ([]*Result, error) {
+	if len(c.Transitions) == 0 {
+		return nil, fmt.Errorf("contract has no transitions")
+	}
+
+	privKeys, err := c.privKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := c.StartOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		results []*Result
+		prev    = []*OutputState{start}
+	)
+	for i, t := range c.Transitions {
+		if t.Input < 0 || t.Input >= len(prev) {
+			return nil, fmt.Errorf("transition %d: input %d out "+
+				"of range", i, t.Input)
+		}
+
+		res, err := c.transition(t, prev[t.Input], privKeys)
+		if err != nil {
+			return nil, fmt.Errorf("transition %d: %w", i, err)
+		}
+
+		results = append(results, res)
+		if report != nil {
+			report(i, res)
+		}
+
+		if res.Err != nil {
+			break
+		}
+
+		prev = res.Outputs
+	}
+
+	return results, nil
+}
+
+// transition builds and validates the transaction spending the input. If it
+// fails only because the output paid the default fee, the error is a
+// *FeeError.
+func (c *Contract) transition(t Transition, in *OutputState,
+	privKeys map[string][]byte) (*Result, error) {
+
+	// The fee is only paid by a single output without a value.
+	fee := int64(0)
+	if len(t.Outputs) == 1 && t.Outputs[0].Value == 0 {
+		fee = t.fee()
+	}
+
+	res, err := c.spend(t, in, privKeys, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Err == nil || t.Fee != nil || fee == 0 {
+		return res, nil
+	}
+
+	// Check whether the transition is valid without the default fee.
+	noFee, err := c.spend(t, in, privKeys, 0)
+	if err == nil && noFee.Err == nil {
+		res.Err = &FeeError{Err: res.Err, Fee: fee}
+	}
+
+	return res, nil
+}
+
+// spend builds and validates the transaction spending the input, paying the
+// fee from the spent output if the value of the only output is not given.
+func (c *Contract) spend(t Transition, in *OutputState,
+	privKeys map[string][]byte, fee int64) (*Result, error) {
+
+	if in.State == "" {
+		return nil, fmt.Errorf("input is not a contract output")
+	}
+
+	state, err := c.State(in.State)
+	if err != nil {
+		return nil, err
+	}
+
+	scripts, err := state.Scripts()
+	if err != nil {
+		return nil, err
+	}
+
+	leafIndex, err := state.LeafIndex(t.Leaf)
+	if err != nil {
+		return nil, err
+	}
+
+	witness, err := script.ParseWitness(t.Witness)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		outputs     []*OutputState
+		txOutputs   []script.TxOutput
+		outputValue int64
+	)
+	for _, o := range t.Outputs {
+		if o.Value == 0 && len(t.Outputs) == 1 {
+			o.Value = in.TxOut.Value - fee
+		}
+		outputValue += o.Value
+
+		out, err := c.output(o)
+		if err != nil {
+			return nil, err
+		}
+
+		outputs = append(outputs, out)
+		txOutputs = append(txOutputs, script.TxOutput{
+			OutputKey: out.OutputKey,
+			Value:     out.Value,
+		})
+	}
+
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("transition has no outputs")
+	}
+
+	if outputValue > in.TxOut.Value {
+		return nil, fmt.Errorf("outputs spend %d sats, more than the "+
+			"input value of %d sats", outputValue, in.TxOut.Value)
+	}
+
+	// Build without writing the derived keys, such that the report is
+	// the only output.
+	tx, prevOuts, err := script.Build(&script.Options{
+		Scripts:     scripts,
+		ScriptIndex: leafIndex,
+		ScriptType:  script.ScriptTypeP2TR,
+		Witness:     witness,
+		PrivKeys:    privKeys,
+		InputKey:    schnorr.SerializePubKey(in.Keys.NakedKey),
+		InputData:   in.Keys.Data,
+		Input: script.TxInput{
+			OutPoint: in.OutPoint,
+			Value:    in.TxOut.Value,
+		},
+		Outputs: txOutputs,
+	}, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := script.ValidateInputs(tx, prevOuts)
+	if err != nil {
+		return nil, err
+	}
+
+	txid := tx.TxHash()
+	for i, o := range outputs {
+		o.OutPoint = wire.OutPoint{Hash: txid, Index: uint32(i)}
+		o.TxOut = tx.TxOut[i]
+	}
+
+	return &Result{
+		Transition: t,
+		Tx:         tx,
+		PrevOuts:   prevOuts,
+		Outputs:    outputs,
+		Err:        results[0].Err,
+	}, nil
+}
+
+// output derives the keys and output script of the output.
+func (c *Contract) output(o Output) (*OutputState, error) {
+	out := &OutputState{Output: o}
+
+	switch {
+	case o.State != "" && o.Key != "":
+		return nil, fmt.Errorf("output cannot have both state and key")
+
+	case o.State != "":
+		state, err := c.State(o.State)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := hex.DecodeString(o.Data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing data: %w", err)
+		}
+
+		keys, err := state.Keys(data)
+		if err != nil {
+			return nil, err
+		}
+
+		out.Keys = keys
+		out.OutputKey = keys.OutputKey
+
+	case o.Key != "":
+		if o.Data != "" {
+			return nil, fmt.Errorf("data can only be embedded in " +
+				"contract outputs")
+		}
+
+		keyBytes, err := hex.DecodeString(o.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		out.OutputKey, err = schnorr.ParsePubKey(keyBytes)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("output must have either state or key")
+	}
+
+	pkScript, err := txscript.PayToTaprootScript(out.OutputKey)
+	if err != nil {
+		return nil, err
+	}
+
+	out.TxOut = &wire.TxOut{
+		Value:    o.Value,
+		PkScript: pkScript,
+	}
+
+	return out, nil
+}
+
+// privKeys parses the private keys of the contract, generating random keys
+// for empty ones such that the same key is used for all transitions.
+func (c *Contract) privKeys() (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for name, k := range c.PrivKeys {
+		if k == "" {
+			privKey, err := btcec.NewPrivateKey()
+			if err != nil {
+				return nil, err
+			}
+
+			keys[name] = privKey.Serialize()
+			continue
+		}
+
+		keyBytes, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("private key %s: %w", name, err)
+		}
+
+		keys[name] = keyBytes
+	}
+
+	return keys, nil
+}
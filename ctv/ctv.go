@@ -0,0 +1,257 @@
+package ctv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Template holds the components of a transaction committed to by the BIP119
+// default template hash.
+type Template struct {
+	Version    int32
+	LockTime   uint32
+	ScriptSigs [][]byte
+	Sequences  []uint32
+	Outputs    []*wire.TxOut
+	InputIndex uint32
+}
+
+// NewTemplate returns the template of the transaction, for the input at the
+// given index.
+func NewTemplate(tx *wire.MsgTx, inputIndex int) (*Template, error) {
+	if inputIndex < 0 || inputIndex >= len(tx.TxIn) {
+		return nil, fmt.Errorf("input index %d out of range",
+			inputIndex)
+	}
+
+	t := &Template{
+		Version:    tx.Version,
+		LockTime:   tx.LockTime,
+		Outputs:    tx.TxOut,
+		InputIndex: uint32(inputIndex),
+	}
+	for _, in := range tx.TxIn {
+		t.ScriptSigs = append(t.ScriptSigs, in.SignatureScript)
+		t.Sequences = append(t.Sequences, in.Sequence)
+	}
+
+	return t, nil
+}
+
+// Hash returns the DefaultCheckTemplateVerifyHash of the transaction for the
+// input at the given index.
+func Hash(tx *wire.MsgTx, inputIndex int) ([32]byte, error) {
+	t, err := NewTemplate(tx, inputIndex)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return t.Hash(), nil
+}
+
+// HasScriptSigs returns whether any input has a non-empty scriptSig, in which
+// case the scriptSigs are committed to.
+func (t *Template) HasScriptSigs() bool {
+	for _, s := range t.ScriptSigs {
+		if len(s) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ScriptSigsHash returns the hash of the scriptSigs, or nil if all are empty.
+func (t *Template) ScriptSigsHash() []byte {
+	if !t.HasScriptSigs() {
+		return nil
+	}
+
+	var b bytes.Buffer
+	for _, s := range t.ScriptSigs {
+		_ = wire.WriteVarBytes(&b, 0, s)
+	}
+
+	h := sha256.Sum256(b.Bytes())
+	return h[:]
+}
+
+// SequencesHash returns the hash of the input sequences.
+func (t *Template) SequencesHash() [32]byte {
+	var b bytes.Buffer
+	for _, s := range t.Sequences {
+		_ = binary.Write(&b, binary.LittleEndian, s)
+	}
+
+	return sha256.Sum256(b.Bytes())
+}
+
+// OutputsHash returns the hash of the serialized outputs.
+func (t *Template) OutputsHash() [32]byte {
+	var b bytes.Buffer
+	for _, o := range t.Outputs {
+		_ = wire.WriteTxOut(&b, 0, 0, o)
+	}
+
+	return sha256.Sum256(b.Bytes())
+}
+
+// Hash returns the DefaultCheckTemplateVerifyHash committing to the
+// template.
+func (t *Template) Hash() [32]byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, t.Version)
+	_ = binary.Write(&b, binary.LittleEndian, t.LockTime)
+	b.Write(t.ScriptSigsHash())
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(t.Sequences)))
+
+	sequencesHash := t.SequencesHash()
+	b.Write(sequencesHash[:])
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(t.Outputs)))
+
+	outputsHash := t.OutputsHash()
+	b.Write(outputsHash[:])
+	_ = binary.Write(&b, binary.LittleEndian, t.InputIndex)
+
+	return sha256.Sum256(b.Bytes())
+}
+
+// Field is a component of the template, as shown when comparing templates.
+type Field struct {
+	// Name is the name of the field.
+	Name string
+
+	// Value is the value of the field.
+	Value string
+
+	// Expected is the value of the field in the expected template, only
+	// set when comparing.
+	Expected string
+}
+
+// Differs returns whether the field differs from the expected value.
+func (f *Field) Differs() bool {
+	return f.Value != f.Expected
+}
+
+// Fields returns the fields of the template in the order they are committed
+// to. Outputs and sequences are listed individually after their hash.
+func (t *Template) Fields() []Field {
+	fields := []Field{
+		{Name: "version", Value: fmt.Sprintf("%d", t.Version)},
+		{Name: "locktime", Value: fmt.Sprintf("%d", t.LockTime)},
+	}
+
+	if t.HasScriptSigs() {
+		fields = append(fields, Field{
+			Name:  "scriptsigs hash",
+			Value: fmt.Sprintf("%x", t.ScriptSigsHash()),
+		})
+		for i, s := range t.ScriptSigs {
+			fields = append(fields, Field{
+				Name:  fmt.Sprintf("scriptsig[%d]", i),
+				Value: fmt.Sprintf("%x", s),
+			})
+		}
+	} else {
+		fields = append(fields, Field{
+			Name:  "scriptsigs hash",
+			Value: "<not committed, all empty>",
+		})
+	}
+
+	sequencesHash := t.SequencesHash()
+	fields = append(fields,
+		Field{
+			Name:  "inputs",
+			Value: fmt.Sprintf("%d", len(t.Sequences)),
+		},
+		Field{
+			Name:  "sequences hash",
+			Value: fmt.Sprintf("%x", sequencesHash[:]),
+		},
+	)
+	for i, s := range t.Sequences {
+		fields = append(fields, Field{
+			Name:  fmt.Sprintf("sequence[%d]", i),
+			Value: fmt.Sprintf("%d", s),
+		})
+	}
+
+	outputsHash := t.OutputsHash()
+	fields = append(fields,
+		Field{
+			Name:  "outputs",
+			Value: fmt.Sprintf("%d", len(t.Outputs)),
+		},
+		Field{
+			Name:  "outputs hash",
+			Value: fmt.Sprintf("%x", outputsHash[:]),
+		},
+	)
+	for i, o := range t.Outputs {
+		fields = append(fields, Field{
+			Name:  fmt.Sprintf("output[%d]", i),
+			Value: fmt.Sprintf("%x:%d", o.PkScript, o.Value),
+		})
+	}
+
+	hash := t.Hash()
+	fields = append(fields,
+		Field{
+			Name:  "input index",
+			Value: fmt.Sprintf("%d", t.InputIndex),
+		},
+		Field{
+			Name:  "hash",
+			Value: fmt.Sprintf("%x", hash[:]),
+		},
+	)
+
+	return fields
+}
+
+// Compare compares the template field by field against the expected
+// template. Fields only present in one of the templates, like an output
+// missing from the other, are given the value "<missing>".
+func (t *Template) Compare(expected *Template) []Field {
+	const missing = "<missing>"
+
+	fields := t.Fields()
+	expFields := expected.Fields()
+
+	expValues := make(map[string]string)
+	for _, f := range expFields {
+		expValues[f.Name] = f.Value
+	}
+
+	seen := make(map[string]bool)
+	for i, f := range fields {
+		seen[f.Name] = true
+		fields[i].Expected = missing
+		if v, ok := expValues[f.Name]; ok {
+			fields[i].Expected = v
+		}
+	}
+
+	// Add fields only in the expected template, before the final hash.
+	hash := fields[len(fields)-1]
+	fields = fields[:len(fields)-1]
+	for _, f := range expFields {
+		if seen[f.Name] {
+			continue
+		}
+
+		fields = append(fields, Field{
+			Name:     f.Name,
+			Value:    missing,
+			Expected: f.Value,
+		})
+	}
+
+	return append(fields, hash)
+}
@@ -0,0 +1,252 @@
+package dap
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/halseth/tapsim/script"
+)
+
+// mode is how execution is resumed after being stopped.
+type mode int
+
+const (
+	// modeContinue runs until a breakpoint is hit.
+	modeContinue mode = iota
+
+	// modeStepIn stops at the next opcode.
+	modeStepIn
+
+	// modeNext stops at the next line, or the end of the script.
+	modeNext
+
+	// modeStepOut stops at the first step of the next script.
+	modeStepOut
+)
+
+// location is where a step is in the sources shown to the client.
+type location struct {
+	scriptIndex int
+	source      sourceKey
+	line        int
+
+	// end is set at the end of the script, after the last opcode.
+	end bool
+}
+
+// debugger is the observer stopping execution at breakpoints and steps
+// requested by the client. While stopped, the VM is blocked in OnStep until
+// execution is resumed.
+type debugger struct {
+	s *session
+
+	resumed chan mode
+	done    chan struct{}
+
+	mu sync.Mutex
+
+	// mode is how execution was last resumed, from the location at
+	// from.
+	mode mode
+	from location
+
+	// last is the location of the previous step, such that a breakpoint
+	// on a line with several opcodes is only hit once.
+	last location
+
+	// pausing is set when the client requested a pause, and aborting
+	// when it disconnected.
+	pausing  bool
+	aborting bool
+
+	// step is the step we are stopped at, nil while running.
+	step    *script.Step
+	stepLoc location
+	stepSrc *source
+
+	// scripts are the scripts executed so far, by script index.
+	scripts map[int][]string
+}
+
+// newDebugger returns a debugger for the session.
+func newDebugger(s *session) *debugger {
+	return &debugger{
+		s:       s,
+		resumed: make(chan mode),
+		done:    make(chan struct{}),
+		scripts: make(map[int][]string),
+	}
+}
+
+// OnStep stops execution if the step hits a breakpoint or ends a step
+// requested by the client, and waits for it to be resumed.
+func (d *debugger) OnStep(step *script.Step) script.Command {
+	loc, src := d.s.locate(step)
+	bpID, bp := d.s.breakpointAt(loc)
+
+	d.mu.Lock()
+	d.scripts[step.State.ScriptIndex] = step.Script
+
+	if d.aborting {
+		d.mu.Unlock()
+		return script.CommandAbort
+	}
+
+	var reason string
+	switch {
+	case step.State.Step == 1 && d.s.prog.StopOnEntry:
+		reason = "entry"
+
+	case d.pausing:
+		reason = "pause"
+
+	case d.mode == modeStepIn:
+		reason = "step"
+
+	case d.mode == modeNext && loc != d.from:
+		reason = "step"
+
+	case d.mode == modeStepOut &&
+		loc.scriptIndex != d.from.scriptIndex:
+
+		reason = "step"
+
+	case bp && (loc.source != d.last.source || loc.line != d.last.line):
+		reason = "breakpoint"
+	}
+	d.last = loc
+
+	if reason == "" {
+		d.mu.Unlock()
+		return script.CommandStep
+	}
+
+	d.pausing = false
+	d.step = step
+	d.stepLoc = loc
+	d.stepSrc = src
+	d.mu.Unlock()
+
+	stopped := &stoppedEvent{
+		Reason:            reason,
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+	}
+	if reason == "breakpoint" {
+		stopped.HitBreakpointIDs = []int{bpID}
+	}
+	d.s.conn.event("stopped", stopped)
+
+	m := <-d.resumed
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.aborting {
+		return script.CommandAbort
+	}
+
+	d.mode = m
+	d.from = loc
+
+	return script.CommandStep
+}
+
+// OnDone reports the result of the execution to the client, and that it
+// ended.
+func (d *debugger) OnDone(err error) {
+	defer close(d.done)
+
+	if errors.Is(err, script.ErrAborted) {
+		d.s.conn.event("terminated", nil)
+		return
+	}
+
+	exitCode := 0
+	msg := "script execution verified\n"
+	if err != nil {
+		exitCode = 1
+
+		var f *script.Failure
+		if errors.As(err, &f) {
+			msg = f.String()
+		} else {
+			msg = fmt.Sprintf("script execution failed: %v\n", err)
+		}
+	}
+
+	d.s.conn.event("output", &outputEvent{
+		Category: "console",
+		Output:   msg,
+	})
+	d.s.conn.event("exited", &exitedEvent{
+		ExitCode: exitCode,
+	})
+	d.s.conn.event("terminated", nil)
+}
+
+// stopped returns the step execution is stopped at, or nil if it is running.
+func (d *debugger) stopped() *script.Step {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.step
+}
+
+// current returns the step execution is stopped at, together with its
+// location and source.
+func (d *debugger) current() (*script.Step, location, *source) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.step, d.stepLoc, d.stepSrc
+}
+
+// script returns the opcodes of the script with the given index, if it has
+// been executed.
+func (d *debugger) script(idx int) ([]string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	opcodes, ok := d.scripts[idx]
+	return opcodes, ok
+}
+
+// resume resumes execution if it is stopped.
+func (d *debugger) resume(m mode) {
+	d.mu.Lock()
+	if d.step == nil {
+		d.mu.Unlock()
+		return
+	}
+	d.step = nil
+	d.mu.Unlock()
+
+	d.resumed <- m
+}
+
+// pause stops execution at the next step.
+func (d *debugger) pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.step == nil {
+		d.pausing = true
+	}
+}
+
+// stop aborts execution, and waits for it to end.
+func (d *debugger) stop() {
+	d.mu.Lock()
+	d.aborting = true
+	stopped := d.step != nil
+	d.step = nil
+	d.mu.Unlock()
+
+	if stopped {
+		d.resumed <- modeContinue
+	}
+
+	<-d.done
+}
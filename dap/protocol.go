@@ -0,0 +1,223 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// request is a request from the client.
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// response is the response to a request.
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// event is an event sent to the client.
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// maxContentLength is the largest message we accept, such that a bad header
+// can't make us allocate an arbitrary amount of memory. It leaves room for
+// launch arguments holding hex encoded transactions of any standard size.
+const maxContentLength = 16 << 20
+
+// readMessage reads the content of a single message, which is preceded by
+// headers giving its length.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(name, "Content-Length") {
+			continue
+		}
+
+		length, err = strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid content length %q",
+				value)
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	if length > maxContentLength {
+		return nil, fmt.Errorf("content length %d above maximum of %d",
+			length, maxContentLength)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// conn writes messages to the client. Responses are written by the request
+// loop and events from the execution, so writes are serialized.
+type conn struct {
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+// write writes the message returned by msg, given the next sequence number.
+func (c *conn) write(msg func(seq int) interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	content, err := json.Marshal(msg(c.seq))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s",
+		len(content), content)
+	return err
+}
+
+// respond sends the response to the request. If err is set, the request
+// failed with err as message.
+func (c *conn) respond(req *request, body interface{}, err error) error {
+	return c.write(func(seq int) interface{} {
+		resp := &response{
+			Seq:        seq,
+			Type:       "response",
+			RequestSeq: req.Seq,
+			Success:    err == nil,
+			Command:    req.Command,
+			Body:       body,
+		}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+
+		return resp
+	})
+}
+
+// event sends the event with the given body.
+func (c *conn) event(name string, body interface{}) error {
+	return c.write(func(seq int) interface{} {
+		return &event{
+			Seq:   seq,
+			Type:  "event",
+			Event: name,
+			Body:  body,
+		}
+	})
+}
+
+// Bodies and arguments of the messages used, with the fields we need.
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+}
+
+type source struct {
+	Name            string `json:"name,omitempty"`
+	Path            string `json:"path,omitempty"`
+	SourceReference int    `json:"sourceReference,omitempty"`
+}
+
+type sourceBreakpoint struct {
+	Line int `json:"line"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source             `json:"source"`
+	Breakpoints []sourceBreakpoint `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	ID       int    `json:"id"`
+	Verified bool   `json:"verified"`
+	Message  string `json:"message,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type stackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	NamedVariables     int    `json:"namedVariables"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type sourceArguments struct {
+	Source          *source `json:"source"`
+	SourceReference int     `json:"sourceReference"`
+}
+
+type stoppedEvent struct {
+	Reason            string `json:"reason"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+	HitBreakpointIDs  []int  `json:"hitBreakpointIds,omitempty"`
+}
+
+type outputEvent struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+type exitedEvent struct {
+	ExitCode int `json:"exitCode"`
+}
@@ -0,0 +1,570 @@
+// Package dap implements a Debug Adapter Protocol server, such that script
+// execution can be stepped through from editors supporting the protocol.
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/halseth/tapsim/output"
+	"github.com/halseth/tapsim/script"
+)
+
+// threadID is the ID of the single thread we report, the executing input.
+const threadID = 1
+
+// Variable references of the scopes shown at every step.
+const (
+	stackRef = iota + 1
+	altStackRef
+	witnessRef
+)
+
+// Program is the execution to debug.
+type Program struct {
+	// Tx is the transaction, and PrevOuts the outputs it spends.
+	Tx       *wire.MsgTx
+	PrevOuts []*wire.TxOut
+
+	// InputIndex is the index of the input to execute.
+	InputIndex int
+
+	// Source is the source file of the script committed to by the spent
+	// output, like the tapscript leaf. If nil, or if it doesn't match the
+	// executed script, the disassembled script is shown instead.
+	Source *Source
+
+	// Tags maps hex values to human-readable tags shown next to them.
+	Tags map[string]string
+
+	// StopOnEntry stops execution at the first step.
+	StopOnEntry bool
+}
+
+// Source is a script source file.
+type Source struct {
+	// Path is the absolute path of the file.
+	Path string
+
+	// Lines is the line of every opcode of the script, as returned by
+	// file.ScriptLines.
+	Lines []int
+}
+
+// LaunchFunc creates the program to debug from the arguments of a launch
+// request. Anything written to w is shown in the debug console.
+type LaunchFunc func(args json.RawMessage, w io.Writer) (*Program, error)
+
+// sourceKey identifies a source, either a file by path or a disassembled
+// script by reference.
+type sourceKey struct {
+	path string
+	ref  int
+}
+
+// session is a debug session with a single client.
+type session struct {
+	conn   *conn
+	launch LaunchFunc
+
+	prog       *Program
+	execIdx    int
+	configured bool
+	dbg        *debugger
+
+	// breakpoints maps lines of every source with breakpoints to the
+	// breakpoint ID. They are read during execution, so they are guarded
+	// by mu.
+	mu          sync.Mutex
+	breakpoints map[sourceKey]map[int]int
+	nextID      int
+}
+
+// Serve runs a debug session, reading requests from r and writing responses
+// and events to w. It returns when the client disconnects.
+func Serve(r io.Reader, w io.Writer, launch LaunchFunc) error {
+	s := &session{
+		conn:        &conn{w: w},
+		launch:      launch,
+		breakpoints: make(map[sourceKey]map[int]int),
+	}
+	defer s.stop()
+
+	br := bufio.NewReader(r)
+	for {
+		content, err := readMessage(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			return fmt.Errorf("invalid message: %w", err)
+		}
+
+		if req.Type != "request" {
+			continue
+		}
+
+		done, err := s.handle(&req)
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+	}
+}
+
+// handle handles a request, returning true if the session ended.
+func (s *session) handle(req *request) (bool, error) {
+	var (
+		body   interface{}
+		reqErr error
+	)
+	switch req.Command {
+	case "initialize":
+		body = &capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsTerminateRequest:         true,
+		}
+
+	case "launch":
+		reqErr = s.doLaunch(req.Arguments)
+
+	case "setBreakpoints":
+		body, reqErr = s.setBreakpoints(req.Arguments)
+
+	case "setExceptionBreakpoints":
+
+	case "configurationDone":
+		s.configured = true
+
+	case "threads":
+		body = map[string]interface{}{
+			"threads": []thread{{
+				ID:   threadID,
+				Name: fmt.Sprintf("input %d", s.inputIndex()),
+			}},
+		}
+
+	case "stackTrace":
+		body, reqErr = s.stackTrace()
+
+	case "scopes":
+		body, reqErr = s.scopes()
+
+	case "variables":
+		body, reqErr = s.variables(req.Arguments)
+
+	case "source":
+		body, reqErr = s.source(req.Arguments)
+
+	case "continue":
+		reqErr = s.checkStopped()
+		body = map[string]interface{}{
+			"allThreadsContinued": true,
+		}
+
+	case "next", "stepIn", "stepOut":
+		reqErr = s.checkStopped()
+
+	case "pause":
+		if s.dbg != nil {
+			s.dbg.pause()
+		}
+
+	case "disconnect", "terminate":
+		s.stop()
+
+	default:
+		reqErr = fmt.Errorf("unsupported command %q", req.Command)
+	}
+
+	if err := s.conn.respond(req, body, reqErr); err != nil {
+		return false, err
+	}
+
+	if reqErr != nil {
+		return false, nil
+	}
+
+	// Execution is resumed only after responding, such that the
+	// response is sent before the next stopped event.
+	switch req.Command {
+	case "launch":
+		// We are ready for the configuration requests setting
+		// breakpoints now that we know the program.
+		if err := s.conn.event("initialized", nil); err != nil {
+			return false, err
+		}
+
+		s.start()
+
+	case "configurationDone":
+		s.start()
+
+	case "continue":
+		s.dbg.resume(modeContinue)
+
+	case "next":
+		s.dbg.resume(modeNext)
+
+	case "stepIn":
+		s.dbg.resume(modeStepIn)
+
+	case "stepOut":
+		s.dbg.resume(modeStepOut)
+
+	case "disconnect":
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// doLaunch creates the program to debug from the launch arguments. Execution
+// is started once the client is done configuring breakpoints.
+func (s *session) doLaunch(args json.RawMessage) error {
+	if s.prog != nil {
+		return fmt.Errorf("already launched")
+	}
+
+	var out bytes.Buffer
+	prog, err := s.launch(args, &out)
+	if out.Len() > 0 {
+		s.conn.event("output", &outputEvent{
+			Category: "console",
+			Output:   out.String(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	tx := prog.Tx
+	if len(prog.PrevOuts) != len(tx.TxIn) {
+		return fmt.Errorf("got %d prevouts for %d inputs",
+			len(prog.PrevOuts), len(tx.TxIn))
+	}
+
+	if prog.InputIndex < 0 || prog.InputIndex >= len(tx.TxIn) {
+		return fmt.Errorf("input index %d out of range",
+			prog.InputIndex)
+	}
+
+	prevOut := prog.PrevOuts[prog.InputIndex]
+	if prevOut == nil {
+		return fmt.Errorf("missing prevout for input %d",
+			prog.InputIndex)
+	}
+
+	if prog.Source != nil {
+		prog.Source.Path = filepath.Clean(prog.Source.Path)
+	}
+
+	s.prog = prog
+	s.execIdx = script.ExecutedScriptIndex(
+		prevOut.PkScript, tx.TxIn[prog.InputIndex],
+	)
+
+	return nil
+}
+
+// start starts execution if the program is launched and the client is done
+// configuring.
+func (s *session) start() {
+	if s.prog == nil || !s.configured || s.dbg != nil {
+		return
+	}
+
+	s.dbg = newDebugger(s)
+
+	p := s.prog
+	go script.StepScript(
+		script.TxSetup(p.Tx, p.PrevOuts, p.InputIndex),
+		p.Tx.TxIn[p.InputIndex], p.PrevOuts[p.InputIndex].PkScript,
+		s.dbg,
+	)
+}
+
+// stop aborts execution if it is running, and waits for it to end.
+func (s *session) stop() {
+	if s.dbg != nil {
+		s.dbg.stop()
+	}
+}
+
+// inputIndex returns the index of the executed input.
+func (s *session) inputIndex() int {
+	if s.prog == nil {
+		return 0
+	}
+
+	return s.prog.InputIndex
+}
+
+// checkStopped returns an error if execution is not stopped.
+func (s *session) checkStopped() error {
+	if s.dbg == nil || s.dbg.stopped() == nil {
+		return fmt.Errorf("execution is not stopped")
+	}
+
+	return nil
+}
+
+// locate returns the location of the step, and the source it is in. The
+// executed script is shown from its source file if we have it, other scripts
+// are disassembled.
+func (s *session) locate(step *script.Step) (location, *source) {
+	idx := step.State.ScriptIndex
+	loc := location{
+		scriptIndex: idx,
+		end:         step.State.OpcodeIndex >= len(step.Script),
+	}
+
+	// The source file can only be used if every opcode maps to a line.
+	src := s.prog.Source
+	if idx == s.execIdx && src != nil && len(src.Lines) > 0 &&
+		len(src.Lines) == len(step.Script) {
+
+		// The end of the script is shown at the last opcode.
+		i := min(step.State.OpcodeIndex, len(src.Lines)-1)
+
+		loc.source = sourceKey{path: src.Path}
+		loc.line = src.Lines[i]
+
+		return loc, &source{
+			Name: filepath.Base(src.Path),
+			Path: src.Path,
+		}
+	}
+
+	// The disassembled script has one opcode per line, followed by an
+	// empty line for the end of the script.
+	ref := idx + 1
+	loc.source = sourceKey{ref: ref}
+	loc.line = step.State.OpcodeIndex + 1
+
+	return loc, &source{
+		Name:            step.State.ScriptName,
+		SourceReference: ref,
+	}
+}
+
+// setBreakpoints replaces the breakpoints of a source. Breakpoints on lines
+// without opcodes are moved to the next line with one.
+func (s *session) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	key := sourceKey{ref: args.Source.SourceReference}
+	if key.ref == 0 {
+		key.path = filepath.Clean(args.Source.Path)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := make(map[int]int)
+	bps := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		s.nextID++
+		bp := breakpoint{
+			ID:   s.nextID,
+			Line: b.Line,
+		}
+
+		line, err := s.breakpointLine(key, b.Line)
+		if err != nil {
+			bp.Message = err.Error()
+		} else {
+			bp.Verified = true
+			bp.Line = line
+			lines[line] = bp.ID
+		}
+
+		bps = append(bps, bp)
+	}
+	s.breakpoints[key] = lines
+
+	return map[string]interface{}{
+		"breakpoints": bps,
+	}, nil
+}
+
+// breakpointLine returns the line a breakpoint on the given line is set at.
+func (s *session) breakpointLine(key sourceKey, line int) (int, error) {
+	if s.prog == nil {
+		return 0, fmt.Errorf("program not launched")
+	}
+
+	if key.ref > 0 {
+		return line, nil
+	}
+
+	src := s.prog.Source
+	if src == nil || src.Path != key.path {
+		return 0, fmt.Errorf("not the source of the executed script")
+	}
+
+	for _, l := range src.Lines {
+		if l >= line {
+			return l, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no opcodes at or after line %d", line)
+}
+
+// breakpointAt returns the ID of the breakpoint at the location, if any.
+func (s *session) breakpointAt(loc location) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.breakpoints[loc.source][loc.line]
+	return id, ok
+}
+
+// stackTrace returns a single frame at the current step.
+func (s *session) stackTrace() (interface{}, error) {
+	if err := s.checkStopped(); err != nil {
+		return nil, err
+	}
+
+	step, loc, src := s.dbg.current()
+
+	opcode := step.State.Opcode
+	if loc.end {
+		opcode = "end of script"
+	}
+
+	return map[string]interface{}{
+		"stackFrames": []stackFrame{{
+			ID: 1,
+			Name: fmt.Sprintf("%s: %s", step.State.ScriptName,
+				opcode),
+			Source: src,
+			Line:   loc.line,
+			Column: 1,
+		}},
+		"totalFrames": 1,
+	}, nil
+}
+
+// scopes returns the stack, alt stack and witness scopes.
+func (s *session) scopes() (interface{}, error) {
+	if err := s.checkStopped(); err != nil {
+		return nil, err
+	}
+
+	step, _, _ := s.dbg.current()
+
+	return map[string]interface{}{
+		"scopes": []scope{
+			{
+				Name:               "Stack",
+				VariablesReference: stackRef,
+				NamedVariables:     len(step.Stack),
+			},
+			{
+				Name:               "Alt stack",
+				VariablesReference: altStackRef,
+				NamedVariables:     len(step.AltStack),
+			},
+			{
+				Name:               "Witness",
+				VariablesReference: witnessRef,
+				NamedVariables:     len(s.witness()),
+			},
+		},
+	}, nil
+}
+
+// witness returns the witness of the executed input.
+func (s *session) witness() wire.TxWitness {
+	return s.prog.Tx.TxIn[s.prog.InputIndex].Witness
+}
+
+// variables returns the elements of a scope, top element first.
+func (s *session) variables(raw json.RawMessage) (interface{}, error) {
+	var args variablesArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkStopped(); err != nil {
+		return nil, err
+	}
+
+	step, _, _ := s.dbg.current()
+
+	var elements [][]byte
+	switch args.VariablesReference {
+	case stackRef:
+		elements = step.Stack
+	case altStackRef:
+		elements = step.AltStack
+	case witnessRef:
+		elements = s.witness()
+	default:
+		return nil, fmt.Errorf("unknown variables reference %d",
+			args.VariablesReference)
+	}
+
+	vars := []variable{}
+	for i, v := range output.StackToString(elements) {
+		if tag, ok := s.prog.Tags[v]; ok {
+			v = fmt.Sprintf("%s (%s)", v, tag)
+		}
+
+		vars = append(vars, variable{
+			Name:  strconv.Itoa(i),
+			Value: v,
+		})
+	}
+
+	return map[string]interface{}{
+		"variables": vars,
+	}, nil
+}
+
+// source returns the disassembly of a script executed so far.
+func (s *session) source(raw json.RawMessage) (interface{}, error) {
+	var args sourceArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	ref := args.SourceReference
+	if args.Source != nil && args.Source.SourceReference != 0 {
+		ref = args.Source.SourceReference
+	}
+
+	if s.dbg == nil {
+		return nil, fmt.Errorf("unknown source reference %d", ref)
+	}
+
+	opcodes, ok := s.dbg.script(ref - 1)
+	if !ok {
+		return nil, fmt.Errorf("unknown source reference %d", ref)
+	}
+
+	return map[string]interface{}{
+		"content": strings.Join(opcodes, "\n") + "\n",
+	}, nil
+}
@@ -0,0 +1,84 @@
+package descriptor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// inputCharset is the character set of descriptors, as defined in BIP-380.
+const inputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+	"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+	"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+
+// checksumCharset is the character set of the descriptor checksum.
+const checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// polyMod is the BCH code generator used for descriptor checksums.
+func polyMod(c uint64, val int) uint64 {
+	c0 := c >> 35
+	c = ((c & 0x7ffffffff) << 5) ^ uint64(val)
+	if c0&1 != 0 {
+		c ^= 0xf5dee51989
+	}
+	if c0&2 != 0 {
+		c ^= 0xa9fdca3312
+	}
+	if c0&4 != 0 {
+		c ^= 0x1bab10e32d
+	}
+	if c0&8 != 0 {
+		c ^= 0x3706b1677a
+	}
+	if c0&16 != 0 {
+		c ^= 0x644d626ffd
+	}
+
+	return c
+}
+
+// Checksum returns the 8 character checksum of the descriptor.
+func Checksum(desc string) (string, error) {
+	c := uint64(1)
+	cls := 0
+	clsCount := 0
+	for _, ch := range desc {
+		pos := strings.IndexRune(inputCharset, ch)
+		if pos < 0 {
+			return "", fmt.Errorf("invalid character %q in "+
+				"descriptor", ch)
+		}
+
+		c = polyMod(c, pos&31)
+		cls = cls*3 + (pos >> 5)
+		clsCount++
+		if clsCount == 3 {
+			c = polyMod(c, cls)
+			cls = 0
+			clsCount = 0
+		}
+	}
+	if clsCount > 0 {
+		c = polyMod(c, cls)
+	}
+	for i := 0; i < 8; i++ {
+		c = polyMod(c, 0)
+	}
+	c ^= 1
+
+	var b strings.Builder
+	for i := 0; i < 8; i++ {
+		b.WriteByte(checksumCharset[(c>>(5*(7-i)))&31])
+	}
+
+	return b.String(), nil
+}
+
+// AddChecksum returns the descriptor with its checksum appended.
+func AddChecksum(desc string) (string, error) {
+	sum, err := Checksum(desc)
+	if err != nil {
+		return "", err
+	}
+
+	return desc + "#" + sum, nil
+}
@@ -0,0 +1,130 @@
+package descriptor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/halseth/tapsim/miniscript"
+)
+
+// KeyParser returns a function parsing key expressions as found in wallet
+// exported descriptors, returning the x-only key. A key expression is an
+// optional [fingerprint/path] key origin, followed by either a hex encoded
+// x-only or compressed public key, or an extended key with an optional
+// derivation path. A * at the end of the path is replaced by index.
+//
+// Hardened derivation steps are only supported for extended private keys,
+// and multipath expressions like <0;1> are not supported.
+func KeyParser(index uint32) miniscript.KeyFunc {
+	return func(s string) ([]byte, error) {
+		return parseKey(s, index)
+	}
+}
+
+// parseKey parses the key expression, deriving wildcards at the index.
+func parseKey(s string, index uint32) ([]byte, error) {
+	key, err := stripOrigin(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// Plain keys are hex encoded, while extended keys are base58.
+	if _, err := hex.DecodeString(key); err == nil {
+		return miniscript.HexKey(key)
+	}
+
+	parts := strings.Split(key, "/")
+	extKey, err := hdkeychain.NewKeyFromString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("key %s: must be hex or an extended "+
+			"key: %w", s, err)
+	}
+
+	for _, p := range parts[1:] {
+		i, err := derivationIndex(p, index)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", s, err)
+		}
+
+		if i >= hdkeychain.HardenedKeyStart && !extKey.IsPrivate() {
+			return nil, fmt.Errorf("key %s: hardened derivation "+
+				"step %s needs an extended private key", s, p)
+		}
+
+		extKey, err = extKey.Derive(i)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", s, err)
+		}
+	}
+
+	pubKey, err := extKey.ECPubKey()
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", s, err)
+	}
+
+	return schnorr.SerializePubKey(pubKey), nil
+}
+
+// stripOrigin returns the key without its [fingerprint/path] origin, checking
+// the origin is well formed. The origin only documents where the key comes
+// from, it is not needed to derive it.
+func stripOrigin(s string) (string, error) {
+	if !strings.HasPrefix(s, "[") {
+		return s, nil
+	}
+
+	end := strings.Index(s, "]")
+	if end < 0 {
+		return "", fmt.Errorf("key %s: missing ] in key origin", s)
+	}
+
+	parts := strings.Split(s[1:end], "/")
+	if fp, err := hex.DecodeString(parts[0]); err != nil || len(fp) != 4 {
+		return "", fmt.Errorf("key %s: key origin must start with a 4 "+
+			"byte hex fingerprint", s)
+	}
+
+	for _, p := range parts[1:] {
+		if _, err := derivationIndex(p, 0); err != nil ||
+			strings.Contains(p, "*") {
+
+			return "", fmt.Errorf("key %s: invalid key origin "+
+				"step %s", s, p)
+		}
+	}
+
+	return s[end+1:], nil
+}
+
+// derivationIndex parses a step of a derivation path, where a trailing ' or h
+// marks hardened derivation and * is replaced by index.
+func derivationIndex(step string, index uint32) (uint32, error) {
+	var hardened uint32
+	if strings.HasSuffix(step, "'") || strings.HasSuffix(step, "h") {
+		hardened = hdkeychain.HardenedKeyStart
+		step = step[:len(step)-1]
+	}
+
+	if strings.HasPrefix(step, "<") {
+		return 0, fmt.Errorf("multipath step %s not supported", step)
+	}
+
+	if step == "*" {
+		if index >= hdkeychain.HardenedKeyStart {
+			return 0, fmt.Errorf("index %d out of range", index)
+		}
+
+		return index + hardened, nil
+	}
+
+	i, err := strconv.ParseUint(step, 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("invalid derivation step %s", step)
+	}
+
+	return uint32(i) + hardened, nil
+}
@@ -0,0 +1,237 @@
+package descriptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/halseth/tapsim/asm"
+	"github.com/halseth/tapsim/miniscript"
+	"github.com/halseth/tapsim/output"
+)
+
+// TaprootOutput is the output described by a tr() descriptor.
+type TaprootOutput struct {
+	// InternalKey is the internal key of the output.
+	InternalKey *btcec.PublicKey
+
+	// Tree is the script tree of the output, with the leaves indexed in
+	// the order they appear in the descriptor. It is nil for outputs
+	// without a script tree.
+	Tree *txscript.IndexedTapScriptTree
+
+	// Scripts are the leaf scripts as text, in the same order as the
+	// leaves in the tree. Miniscript leaves are given in the format
+	// accepted by script.Parse, raw leaves are disassembled.
+	Scripts []string
+}
+
+// ParseTaproot parses a tr(KEY) or tr(KEY,TREE) descriptor. The checksum is
+// optional, but verified if present. Keys are parsed by KeyParser, such that
+// keys with origins and extended keys exported by wallets are accepted, with
+// wildcards derived at index.
+//
+// Leaves can be raw(HEX) scripts or miniscript expressions, and the tree is
+// built exactly as given by the {LEFT,RIGHT} branches of the descriptor.
+func ParseTaproot(desc string, index uint32) (*TaprootOutput, error) {
+	desc = strings.TrimSpace(desc)
+	if i := strings.Index(desc, "#"); i >= 0 {
+		sum, err := Checksum(desc[:i])
+		if err != nil {
+			return nil, err
+		}
+
+		if sum != desc[i+1:] {
+			return nil, fmt.Errorf("invalid descriptor checksum %s, "+
+				"expected %s", desc[i+1:], sum)
+		}
+
+		desc = desc[:i]
+	}
+
+	if !strings.HasPrefix(desc, "tr(") || !strings.HasSuffix(desc, ")") {
+		return nil, fmt.Errorf("not a tr() descriptor: %s", desc)
+	}
+
+	args, err := split(desc[len("tr(") : len(desc)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 2 {
+		return nil, fmt.Errorf("tr() takes at most 2 arguments, got "+
+			"%d", len(args))
+	}
+
+	keys := KeyParser(index)
+	keyBytes, err := keys(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	internalKey, err := schnorr.ParsePubKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &TaprootOutput{
+		InternalKey: internalKey,
+	}
+
+	if len(args) == 1 {
+		return out, nil
+	}
+
+	root, err := parseTree(args[1], keys, out)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Tree = &txscript.IndexedTapScriptTree{
+		RootNode:       root,
+		LeafProofIndex: make(map[chainhash.Hash]int),
+	}
+	addProofs(out.Tree, root, nil)
+
+	return out, nil
+}
+
+// parseTree parses the tree expression, adding the leaf scripts to the
+// output in the order they are found.
+func parseTree(s string, keys miniscript.KeyFunc,
+	out *TaprootOutput) (txscript.TapNode, error) {
+
+	if !strings.HasPrefix(s, "{") {
+		leaf, script, err := parseLeaf(s, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		out.Scripts = append(out.Scripts, leaf)
+
+		return txscript.NewBaseTapLeaf(script), nil
+	}
+
+	if !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("missing } in %s", s)
+	}
+
+	branches, err := split(s[1 : len(s)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(branches) != 2 {
+		return nil, fmt.Errorf("branch must have exactly two children: "+
+			"%s", s)
+	}
+
+	left, err := parseTree(branches[0], keys, out)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := parseTree(branches[1], keys, out)
+	if err != nil {
+		return nil, err
+	}
+
+	return txscript.NewTapBranch(left, right), nil
+}
+
+// parseLeaf parses a leaf expression, returning the script both as text and
+// serialized.
+func parseLeaf(s string, keys miniscript.KeyFunc) (string, []byte, error) {
+	if strings.HasPrefix(s, "raw(") && strings.HasSuffix(s, ")") {
+		script, err := hex.DecodeString(s[len("raw(") : len(s)-1])
+		if err != nil {
+			return "", nil, fmt.Errorf("leaf %s: %w", s, err)
+		}
+
+		text := strings.Join(output.ScriptToString(script), " ")
+		return text, script, nil
+	}
+
+	tokens, err := miniscript.Compile(s, keys)
+	if err != nil {
+		return "", nil, fmt.Errorf("leaf %s: %w", s, err)
+	}
+
+	script, err := asm.Assemble(tokens)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return miniscript.String(tokens), script, nil
+}
+
+// addProofs adds the merkle inclusion proofs of all leaves below the node to
+// the tree. siblings are the hashes of the siblings of the nodes on the path
+// from the node up to the root.
+func addProofs(tree *txscript.IndexedTapScriptTree, node txscript.TapNode,
+	siblings []chainhash.Hash) {
+
+	if leaf, ok := node.(txscript.TapLeaf); ok {
+		var proof bytes.Buffer
+		for _, h := range siblings {
+			proof.Write(h[:])
+		}
+
+		tree.LeafProofIndex[leaf.TapHash()] = len(tree.LeafMerkleProofs)
+		tree.LeafMerkleProofs = append(tree.LeafMerkleProofs,
+			txscript.TapscriptProof{
+				TapLeaf:        leaf,
+				RootNode:       tree.RootNode,
+				InclusionProof: proof.Bytes(),
+			},
+		)
+		return
+	}
+
+	left, right := node.Left(), node.Right()
+	addProofs(tree, left, prepend(right.TapHash(), siblings))
+	addProofs(tree, right, prepend(left.TapHash(), siblings))
+}
+
+// prepend returns a new slice with h followed by the hashes.
+func prepend(h chainhash.Hash, hashes []chainhash.Hash) []chainhash.Hash {
+	return append([]chainhash.Hash{h}, hashes...)
+}
+
+// split splits the expression on the commas that are not nested inside
+// parentheses or braces.
+func split(s string) ([]string, error) {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+	for i, c := range s {
+		switch c {
+		case '(', '{':
+			depth++
+		case ')', '}':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced %q in %s", c,
+					s)
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced expression %s", s)
+	}
+
+	return append(parts, s[start:]), nil
+}
@@ -0,0 +1,91 @@
+package descriptor
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// Taproot returns the tr() descriptor for the output with the given internal
+// key and script tree, with checksum. The tree can be nil for key-spend only
+// outputs.
+//
+// Leaves that are a single key checked with OP_CHECKSIG are written as
+// pk(KEY). Other leaves are written as raw(HEX), which is understood by
+// tapsim, but not by wallets that only accept miniscript leaves. The returned
+// bool is false if the descriptor has such leaves, and is therefore
+// non-standard.
+func Taproot(internalKey *btcec.PublicKey, tree txscript.TapNode) (string,
+	bool, error) {
+
+	standard := true
+	desc := fmt.Sprintf("tr(%x", schnorr.SerializePubKey(internalKey))
+	if tree != nil {
+		treeStr, err := treeString(tree, &standard)
+		if err != nil {
+			return "", false, err
+		}
+		desc += "," + treeStr
+	}
+	desc += ")"
+
+	desc, err := AddChecksum(desc)
+	if err != nil {
+		return "", false, err
+	}
+
+	return desc, standard, nil
+}
+
+// RawTaproot returns the rawtr() descriptor for the given taproot output key,
+// with checksum.
+func RawTaproot(outputKey *btcec.PublicKey) (string, error) {
+	return AddChecksum(
+		fmt.Sprintf("rawtr(%x)", schnorr.SerializePubKey(outputKey)),
+	)
+}
+
+// treeString returns the descriptor notation of the tree, where branches are
+// written {LEFT,RIGHT}. standard is cleared if a leaf is written as raw(HEX).
+func treeString(node txscript.TapNode, standard *bool) (string, error) {
+	if leaf, ok := node.(txscript.TapLeaf); ok {
+		if leaf.LeafVersion != txscript.BaseLeafVersion {
+			return "", fmt.Errorf("unsupported leaf version %d",
+				leaf.LeafVersion)
+		}
+
+		s, ok := leafString(leaf.Script)
+		if !ok {
+			*standard = false
+		}
+
+		return s, nil
+	}
+
+	left, err := treeString(node.Left(), standard)
+	if err != nil {
+		return "", err
+	}
+
+	right, err := treeString(node.Right(), standard)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("{%s,%s}", left, right), nil
+}
+
+// leafString returns the descriptor notation of the leaf script, and whether
+// it is miniscript rather than raw(HEX).
+func leafString(script []byte) (string, bool) {
+	// <KEY> OP_CHECKSIG.
+	if len(script) == 34 && script[0] == txscript.OP_DATA_32 &&
+		script[33] == txscript.OP_CHECKSIG {
+
+		return fmt.Sprintf("pk(%x)", script[1:33]), true
+	}
+
+	return fmt.Sprintf("raw(%x)", script), false
+}
@@ -1,10 +1,8 @@
 package main
 
 import (
-	"encoding/hex"
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
@@ -66,15 +64,15 @@ func run(num int) error {
 		balances = append(balances, bal)
 	}
 
-	var leaves []string
+	var leaves [][]byte
 	for i := 0; i < num; i++ {
 		pubKey := keys[i].PubKey()
 		pubKeyBytes := schnorr.SerializePubKey(pubKey)
-		l := fmt.Sprintf("(%x,%x)", balances[i].Bytes(), pubKeyBytes)
+		l := build.GroupLeaf(balances[i].Bytes(), pubKeyBytes)
 		leaves = append(leaves, l)
 	}
 
-	tree, err := build.Merkle(leaves)
+	tree, err := build.New(leaves, build.Options{})
 	if err != nil {
 		return err
 	}
@@ -128,19 +126,9 @@ func run(num int) error {
 	)
 
 	fmt.Println("merkle tree 1:")
-	for i := len(tree) - 1; i >= 0; i-- {
-		fmt.Printf("%s\n", tree[i])
-	}
-	for _, l := range leaves {
-		fmt.Printf("%s ", l)
-	}
-	fmt.Println()
+	printTree(tree)
 
-	rootStr := tree[len(tree)-1]
-	root, err := hex.DecodeString(rootStr)
-	if err != nil {
-		return err
-	}
+	root := tree.Root()
 	tweaked1 := txscript.SingleTweakPubKey(innerKey, root[:])
 	tapKey1 := txscript.ComputeTaprootOutputKey(tweaked1, tapScriptRootHash[:])
 	fmt.Printf("tweaked key1: %x tapkey1: %x\n",
@@ -157,28 +145,18 @@ func run(num int) error {
 
 	var witness []string
 	witness = append(witness, fmt.Sprintf("%x", schnorr.SerializePubKey(innerKey)))
-	witness = append(witness, rootStr)
+	witness = append(witness, fmt.Sprintf("%x", root))
 
 	// First exiting.
-	leaves[0] = "<>"
-	tree, err = build.Merkle(leaves)
+	tree, err = tree.Update(0, []byte{})
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("merkle tree 2:")
-	for i := len(tree) - 1; i >= 0; i-- {
-		fmt.Printf("%s\n", tree[i])
-	}
-	for _, l := range leaves {
-		fmt.Printf("%s ", l)
-	}
-	fmt.Println()
-	root, err = hex.DecodeString(rootStr)
-	if err != nil {
-		return err
-	}
+	printTree(tree)
 
+	root = tree.Root()
 	tweaked2 := txscript.SingleTweakPubKey(innerKey, root[:])
 	tapKey2 := txscript.ComputeTaprootOutputKey(tweaked2, tapScriptRootHash[:])
 	fmt.Printf("tweaked key2: %x tapkey2: %x\n",
@@ -193,29 +171,24 @@ func run(num int) error {
 	)
 	witness = append(witness, "<>")
 
-	witness = append(witness, fmt.Sprintf("%s", strings.Split(tree[0], " ")[1]))
+	proof, err := tree.Proof(0)
+	if err != nil {
+		return err
+	}
+	witness = append(witness, fmt.Sprintf("%x", proof.Siblings[0]))
 	witness = append(witness, "<>")
-	witness = append(witness, fmt.Sprintf("%s", strings.Split(tree[1], " ")[1]))
+	witness = append(witness, fmt.Sprintf("%x", proof.Siblings[1]))
 
 	// Third user exiting.
-	leaves[2] = "<>"
-	tree, err = build.Merkle(leaves)
+	tree, err = tree.Update(2, []byte{})
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("merkle tree 3:")
-	for i := len(tree) - 1; i >= 0; i-- {
-		fmt.Printf("%s\n", tree[i])
-	}
-	for _, l := range leaves {
-		fmt.Printf("%s ", l)
-	}
-	fmt.Println()
-	root, err = hex.DecodeString(tree[len(tree)-1])
-	if err != nil {
-		return err
-	}
+	printTree(tree)
+
+	root = tree.Root()
 	tweaked3 := txscript.SingleTweakPubKey(innerKey, root[:])
 	tapKey3 := txscript.ComputeTaprootOutputKey(tweaked3, tapScriptRootHash[:])
 	fmt.Printf("tweaked key3: %x tapkey3: %x\n",
@@ -235,10 +208,14 @@ func run(num int) error {
 
 	witness = append(witness, "<>")
 
-	witness = append(witness, fmt.Sprintf("%s", strings.Split(tree[0], " ")[3]))
+	proof, err = tree.Proof(2)
+	if err != nil {
+		return err
+	}
+	witness = append(witness, fmt.Sprintf("%x", proof.Siblings[0]))
 	witness = append(witness, "01")
 
-	witness = append(witness, fmt.Sprintf("%s", strings.Split(tree[1], " ")[0]))
+	witness = append(witness, fmt.Sprintf("%x", proof.Siblings[1]))
 
 	witness = append(witness, "<sig:privkey3>")
 	witness = append(witness, "<sig:privkey1>")
@@ -257,3 +234,14 @@ func run(num int) error {
 
 	return nil
 }
+
+// printTree prints the levels of the merkle tree, starting with the root.
+func printTree(tree *build.Tree) {
+	levels := tree.Levels()
+	for i := len(levels) - 1; i >= 0; i-- {
+		for _, n := range levels[i] {
+			fmt.Printf("%x ", n)
+		}
+		fmt.Println()
+	}
+}
@@ -3,9 +3,12 @@ package file
 import (
 	"bufio"
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"os"
 	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
 )
 
 func Read(filename string) ([]byte, error) {
@@ -49,6 +52,31 @@ func ParseScript(data []byte) (string, error) {
 	return script, nil
 }
 
+// ScriptLines returns the 1-based line number of every word of the script as
+// parsed by ParseScript. Since every word is parsed into a single opcode, this
+// maps opcode indexes to source lines.
+func ScriptLines(data []byte) []int {
+	buf := bytes.NewBuffer(data)
+	fileScanner := bufio.NewScanner(buf)
+
+	var (
+		lines  []int
+		lineNo = 0
+	)
+	for fileScanner.Scan() {
+		lineNo++
+		line := fileScanner.Text()
+
+		// Trim comments.
+		line = strings.Split(line, "#")[0]
+		for range strings.Fields(line) {
+			lines = append(lines, lineNo)
+		}
+	}
+
+	return lines
+}
+
 func ParseTagMap(data []byte) (map[string]string, error) {
 	kv := make(map[string]string)
 	if err := json.Unmarshal(data, &kv); err != nil {
@@ -57,3 +85,18 @@ func ParseTagMap(data []byte) (map[string]string, error) {
 
 	return kv, nil
 }
+
+// ParsePsbt parses a PSBT given either in raw binary, hex or base64 encoding.
+func ParsePsbt(data []byte) (*psbt.Packet, error) {
+	// A raw PSBT starts with the magic bytes "psbt".
+	if bytes.HasPrefix(data, []byte("psbt")) {
+		return psbt.NewFromRawBytes(bytes.NewReader(data), false)
+	}
+
+	str := strings.TrimSpace(string(data))
+	if raw, err := hex.DecodeString(str); err == nil {
+		return psbt.NewFromRawBytes(bytes.NewReader(raw), false)
+	}
+
+	return psbt.NewFromRawBytes(strings.NewReader(str), true)
+}
@@ -0,0 +1,227 @@
+package keyring
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// keyTag is the tag used to derive named keys from the seed.
+var keyTag = []byte("tapsim/keyring")
+
+// Keyring holds named private keys. Keys not explicitly added are derived
+// deterministically from the seed and their name, such that the same seed
+// always gives the same keys.
+type Keyring struct {
+	seed []byte
+	keys map[string]*btcec.PrivateKey
+}
+
+// New creates a keyring deriving keys from the given seed. If the seed is
+// empty, only keys explicitly added can be used.
+func New(seed []byte) *Keyring {
+	return &Keyring{
+		seed: seed,
+		keys: make(map[string]*btcec.PrivateKey),
+	}
+}
+
+// File is the format of a keyring file.
+type File struct {
+	// Seed is the hex encoded seed keys are derived from.
+	Seed string `json:"seed"`
+
+	// Keys maps key names to either a hex encoded private key, a BIP32
+	// derivation path like m/86'/0'/0'/0/0 from the seed, or the empty
+	// string to derive the key from the seed and the name.
+	Keys map[string]string `json:"keys"`
+}
+
+// Load reads a keyring from the given JSON file.
+func Load(filename string) (*Keyring, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+
+	seed, err := hex.DecodeString(f.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("parsing seed: %w", err)
+	}
+
+	k := New(seed)
+	for name, v := range f.Keys {
+		if err := k.Add(name, v); err != nil {
+			return nil, fmt.Errorf("key %s: %w", name, err)
+		}
+	}
+
+	return k, nil
+}
+
+// Add adds the named key, given in the same format as in a keyring file.
+func (k *Keyring) Add(name, key string) error {
+	var (
+		privKey *btcec.PrivateKey
+		err     error
+	)
+	switch {
+	case key == "":
+		privKey, err = k.derive(name)
+
+	case strings.HasPrefix(key, "m/"):
+		privKey, err = k.DerivePath(key)
+
+	default:
+		var keyBytes []byte
+		keyBytes, err = hex.DecodeString(key)
+		if err == nil && len(keyBytes) != btcec.PrivKeyBytesLen {
+			err = fmt.Errorf("private key must be %d bytes",
+				btcec.PrivKeyBytesLen)
+		}
+		if err == nil {
+			privKey, _ = btcec.PrivKeyFromBytes(keyBytes)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	k.keys[name] = privKey
+	return nil
+}
+
+// Key returns the private key with the given name. Keys not added to the
+// keyring are derived from the seed.
+func (k *Keyring) Key(name string) (*btcec.PrivateKey, error) {
+	if privKey, ok := k.keys[name]; ok {
+		return privKey, nil
+	}
+
+	privKey, err := k.derive(name)
+	if err != nil {
+		return nil, err
+	}
+
+	k.keys[name] = privKey
+	return privKey, nil
+}
+
+// Names returns the sorted names of the keys in the keyring.
+func (k *Keyring) Names() []string {
+	var names []string
+	for name := range k.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// HasSeed returns whether the keyring can derive keys from a seed.
+func (k *Keyring) HasSeed() bool {
+	return len(k.seed) > 0
+}
+
+// derive derives the named key as the tagged hash of the seed and name. In
+// the unlikely case the hash is not a valid private key, a counter is
+// appended to the name until it is.
+func (k *Keyring) derive(name string) (*btcec.PrivateKey, error) {
+	if !k.HasSeed() {
+		return nil, fmt.Errorf("unknown key %s and no seed to derive "+
+			"it from", name)
+	}
+
+	for i := 0; ; i++ {
+		msg := []byte(name)
+		if i > 0 {
+			msg = append(msg, []byte(strconv.Itoa(i))...)
+		}
+
+		h := chainhash.TaggedHash(keyTag, k.seed, msg)
+
+		var s btcec.ModNScalar
+		if overflow := s.SetByteSlice(h[:]); overflow || s.IsZero() {
+			continue
+		}
+
+		return btcec.PrivKeyFromScalar(&s), nil
+	}
+}
+
+// DerivePath derives the private key at the BIP32 derivation path from the
+// seed, like m/86'/0'/0'/0/0. Hardened steps are marked with ' or h.
+func (k *Keyring) DerivePath(path string) (*btcec.PrivateKey, error) {
+	if !k.HasSeed() {
+		return nil, fmt.Errorf("no seed to derive %s from", path)
+	}
+
+	key, err := hdkeychain.NewMaster(k.seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := strings.Split(path, "/")
+	if steps[0] != "m" {
+		return nil, fmt.Errorf("path must start with m/")
+	}
+
+	for _, step := range steps[1:] {
+		hardened := strings.HasSuffix(step, "'") ||
+			strings.HasSuffix(step, "h")
+		step = strings.TrimRight(step, "'h")
+
+		index, err := strconv.ParseUint(step, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path step %s: %w", step,
+				err)
+		}
+
+		if hardened {
+			index += hdkeychain.HardenedKeyStart
+		}
+
+		key, err = key.Derive(uint32(index))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key.ECPrivKey()
+}
+
+// Open loads the keyring file if set, or creates a keyring from the hex
+// encoded seed. It returns nil if neither is set.
+func Open(filename, seedHex string) (*Keyring, error) {
+	switch {
+	case filename != "" && seedHex != "":
+		return nil, fmt.Errorf("cannot set both keyring and seed")
+
+	case filename != "":
+		return Load(filename)
+
+	case seedHex != "":
+		seed, err := hex.DecodeString(seedHex)
+		if err != nil {
+			return nil, fmt.Errorf("parsing seed: %w", err)
+		}
+
+		return New(seed), nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,135 @@
+package miniscript
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is a parsed miniscript expression.
+type Node struct {
+	// Wrappers are the wrappers applied to the fragment, like "sdv" in
+	// sdv:older(144). The outermost wrapper comes first.
+	Wrappers string
+
+	// Fragment is the name of the fragment, like and_v or pk_k. It is
+	// empty for arguments that are keys, hashes or numbers.
+	Fragment string
+
+	// Value is the key, hash or number given as argument to a fragment.
+	Value string
+
+	// Args are the arguments of the fragment.
+	Args []*Node
+}
+
+// String returns the miniscript expression of the node.
+func (n *Node) String() string {
+	if n.Fragment == "" {
+		return n.Value
+	}
+
+	var s string
+	if n.Wrappers != "" {
+		s = n.Wrappers + ":"
+	}
+	s += n.Fragment
+
+	if len(n.Args) == 0 {
+		return s
+	}
+
+	var args []string
+	for _, a := range n.Args {
+		args = append(args, a.String())
+	}
+
+	return s + "(" + strings.Join(args, ",") + ")"
+}
+
+// Parse parses the miniscript expression.
+func Parse(expr string) (*Node, error) {
+	p := &parser{s: strings.ReplaceAll(expr, " ", "")}
+	n, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.s[p.pos:],
+			p.pos)
+	}
+
+	return n, nil
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+// parse parses the expression starting at the current position.
+func (p *parser) parse() (*Node, error) {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune("(),", rune(p.s[p.pos])) {
+		p.pos++
+	}
+
+	name := p.s[start:p.pos]
+	if name == "" {
+		return nil, fmt.Errorf("expected expression at position %d",
+			start)
+	}
+
+	n := &Node{}
+	if i := strings.Index(name, ":"); i >= 0 {
+		n.Wrappers = name[:i]
+		name = name[i+1:]
+
+		for _, w := range n.Wrappers {
+			if !strings.ContainsRune("asctdvjnlu", w) {
+				return nil, fmt.Errorf("unknown wrapper %q", w)
+			}
+		}
+	}
+
+	// Without arguments this is either the 0 or 1 fragment, or an
+	// argument to the parent fragment.
+	if p.pos == len(p.s) || p.s[p.pos] != '(' {
+		switch {
+		case name == "0" || name == "1":
+			n.Fragment = name
+		case n.Wrappers != "":
+			return nil, fmt.Errorf("wrappers applied to %q", name)
+		default:
+			n.Value = name
+		}
+
+		return n, nil
+	}
+
+	n.Fragment = name
+	p.pos++
+	for {
+		arg, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		n.Args = append(n.Args, arg)
+
+		if p.pos == len(p.s) {
+			return nil, fmt.Errorf("missing ) in %s", name)
+		}
+
+		c := p.s[p.pos]
+		p.pos++
+		if c == ')' {
+			break
+		}
+		if c != ',' {
+			return nil, fmt.Errorf("unexpected %q at position %d",
+				c, p.pos-1)
+		}
+	}
+
+	return n, nil
+}
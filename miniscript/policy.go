@@ -0,0 +1,148 @@
+package miniscript
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CompilePolicy compiles a policy expression into miniscript. Policies are
+// built from pk(KEY), after(N), older(N), sha256(H), hash256(H),
+// ripemd160(H), hash160(H), and(X,Y), or(X,Y) and thresh(K,X,Y,...).
+// Probabilities given to or() branches as N@X are ignored.
+//
+// The compilation is not optimized for witness size. Every policy operator is
+// compiled to a fixed miniscript fragment: and to and_v, or to or_i, and
+// thresh to multi_a if all arguments are keys, thresh otherwise.
+func CompilePolicy(policy string) (*Node, error) {
+	p, err := Parse(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return compilePolicy(p)
+}
+
+// compilePolicy compiles the parsed policy node into a miniscript node of
+// type B.
+func compilePolicy(p *Node) (*Node, error) {
+	// Strip any probability from or() branches.
+	name := p.Fragment
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	if p.Wrappers != "" {
+		return nil, fmt.Errorf("wrappers not allowed in policy: %s", p)
+	}
+
+	// subs compiles the sub-policies starting at the given argument.
+	subs := func(from int) ([]*Node, error) {
+		var nodes []*Node
+		for _, a := range p.Args[from:] {
+			n, err := compilePolicy(a)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, n)
+		}
+
+		return nodes, nil
+	}
+
+	switch name {
+	case "pk", "after", "older", "sha256", "hash256", "ripemd160",
+		"hash160":
+
+		if len(p.Args) != 1 || argValue(p.Args[0]) == "" {
+			return nil, fmt.Errorf("%s takes a single value "+
+				"argument", name)
+		}
+
+		return &Node{Fragment: name, Args: p.Args}, nil
+
+	case "and":
+		if len(p.Args) != 2 {
+			return nil, fmt.Errorf("and takes 2 arguments")
+		}
+
+		s, err := subs(0)
+		if err != nil {
+			return nil, err
+		}
+
+		s[0].Wrappers = "v" + s[0].Wrappers
+		return &Node{Fragment: "and_v", Args: s}, nil
+
+	case "or":
+		if len(p.Args) != 2 {
+			return nil, fmt.Errorf("or takes 2 arguments")
+		}
+
+		s, err := subs(0)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Node{Fragment: "or_i", Args: s}, nil
+
+	case "thresh":
+		if len(p.Args) < 2 {
+			return nil, fmt.Errorf("thresh needs a threshold and " +
+				"at least one argument")
+		}
+
+		k := &Node{Value: argValue(p.Args[0])}
+
+		s, err := subs(1)
+		if err != nil {
+			return nil, err
+		}
+
+		// If all arguments are keys, we can use multi_a.
+		allKeys := true
+		for _, n := range s {
+			allKeys = allKeys && n.Fragment == "pk"
+		}
+
+		if allKeys {
+			args := []*Node{k}
+			for _, n := range s {
+				args = append(args, n.Args[0])
+			}
+
+			return &Node{Fragment: "multi_a", Args: args}, nil
+		}
+
+		// Otherwise every argument must be made dissatisfiable and
+		// leave a single 0 or 1 on the stack, and all but the first
+		// must be wrapped to work on the element below the top of
+		// the stack.
+		args := []*Node{k}
+		for i, n := range s {
+			if !dissatisfiable(n) {
+				n.Wrappers = "utv" + n.Wrappers
+			}
+			if i > 0 {
+				n.Wrappers = "a" + n.Wrappers
+			}
+
+			args = append(args, n)
+		}
+
+		return &Node{Fragment: "thresh", Args: args}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown policy %s", p.Fragment)
+	}
+}
+
+// dissatisfiable returns true if the compiled node already is a
+// dissatisfiable unit, i.e. a key or a hash lock.
+func dissatisfiable(n *Node) bool {
+	switch n.Fragment {
+	case "pk", "sha256", "hash256", "ripemd160", "hash160":
+		return n.Wrappers == ""
+	}
+
+	return false
+}
@@ -0,0 +1,331 @@
+package miniscript
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Satisfy returns a witness template satisfying the node's script, with the
+// bottom stack element first. Signatures are given as <sig:KEY> placeholders
+// understood by script.ParseWitness, where KEY is the key expression from the
+// miniscript. Hash preimages are given as <preimage:HASH> placeholders, that
+// must be replaced by the actual preimage.
+//
+// Where there are multiple ways to satisfy the script, the leftmost branches
+// are chosen. Time locks are assumed to be satisfied by the spending
+// transaction.
+func (n *Node) Satisfy(keys KeyFunc) ([]string, error) {
+	w, err := n.witnesses(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.sat == nil {
+		return nil, fmt.Errorf("%s cannot be satisfied", n)
+	}
+
+	return w.sat, nil
+}
+
+// witnesses holds the satisfaction and dissatisfaction of a node, with the
+// bottom stack element first. A nil slice means the node cannot be
+// satisfied or dissatisfied.
+type witnesses struct {
+	sat  []string
+	dsat []string
+}
+
+// w returns the witness made from the given elements.
+func w(elements ...string) []string {
+	return append([]string{}, elements...)
+}
+
+// cat concatenates the witnesses, returning nil if any of them are nil.
+func cat(ws ...[]string) []string {
+	c := []string{}
+	for _, w := range ws {
+		if w == nil {
+			return nil
+		}
+		c = append(c, w...)
+	}
+
+	return c
+}
+
+// either returns the first non-nil witness.
+func either(ws ...[]string) []string {
+	for _, w := range ws {
+		if w != nil {
+			return w
+		}
+	}
+
+	return nil
+}
+
+// sigPlaceholder returns the witness placeholder for a signature from the
+// key.
+func sigPlaceholder(key string) string {
+	return fmt.Sprintf("<sig:%s>", key)
+}
+
+// witnesses returns the satisfaction and dissatisfaction of the node.
+func (n *Node) witnesses(keys KeyFunc) (witnesses, error) {
+	res, err := n.fragmentWitnesses(keys)
+	if err != nil {
+		return witnesses{}, err
+	}
+
+	// Apply the wrappers, innermost first.
+	for i := len(n.Wrappers) - 1; i >= 0; i-- {
+		switch n.Wrappers[i] {
+		// These wrappers don't change the (dis)satisfaction.
+		case 'a', 's', 'c', 'n':
+
+		// These can only be satisfied.
+		case 't', 'v':
+			res.dsat = nil
+
+		// d:X is OP_DUP OP_IF X OP_ENDIF.
+		case 'd':
+			res = witnesses{cat(res.sat, w("01")), w("<>")}
+
+		// j:X is OP_SIZE OP_0NOTEQUAL OP_IF X OP_ENDIF.
+		case 'j':
+			res.dsat = w("<>")
+
+		// l:X is or_i(0,X).
+		case 'l':
+			res = witnesses{cat(res.sat, w("<>")), w("01")}
+
+		// u:X is or_i(X,0).
+		case 'u':
+			res = witnesses{cat(res.sat, w("01")), w("<>")}
+		}
+	}
+
+	return res, nil
+}
+
+// fragmentWitnesses returns the satisfaction and dissatisfaction of the
+// fragment without wrappers.
+func (n *Node) fragmentWitnesses(keys KeyFunc) (witnesses, error) {
+	// The threshold of thresh might have been parsed as the 0 or 1
+	// fragment, so we skip it.
+	subs := n.Args
+	if n.Fragment == "thresh" && len(subs) > 0 {
+		subs = subs[1:]
+	}
+
+	var args []witnesses
+	for _, a := range subs {
+		if a.Fragment == "" {
+			continue
+		}
+
+		aw, err := a.witnesses(keys)
+		if err != nil {
+			return witnesses{}, err
+		}
+		args = append(args, aw)
+	}
+
+	// argsNeeded checks that the fragment has the expected number of
+	// sub-fragments.
+	argsNeeded := func(num int) error {
+		if len(args) != num {
+			return fmt.Errorf("%s takes %d arguments, got %d",
+				n.Fragment, num, len(args))
+		}
+		return nil
+	}
+
+	switch n.Fragment {
+	case "0":
+		return witnesses{nil, w()}, nil
+
+	case "1":
+		return witnesses{w(), nil}, nil
+
+	case "pk_k", "pk":
+		return witnesses{
+			w(sigPlaceholder(argValue(n.Args[0]))), w("<>"),
+		}, nil
+
+	case "pk_h", "pkh":
+		k, err := keys(argValue(n.Args[0]))
+		if err != nil {
+			return witnesses{}, err
+		}
+
+		return witnesses{
+			w(sigPlaceholder(argValue(n.Args[0])),
+				hex.EncodeToString(k)),
+			w("<>", hex.EncodeToString(k)),
+		}, nil
+
+	case "older", "after":
+		return witnesses{w(), nil}, nil
+
+	case "sha256", "hash256", "ripemd160", "hash160":
+		// Any 32 byte value that is not the preimage dissatisfies.
+		return witnesses{
+			w(fmt.Sprintf("<preimage:%s>", argValue(n.Args[0]))),
+			w(strings.Repeat("00", 32)),
+		}, nil
+
+	case "andor":
+		if err := argsNeeded(3); err != nil {
+			return witnesses{}, err
+		}
+		x, y, z := args[0], args[1], args[2]
+		return witnesses{
+			either(cat(y.sat, x.sat), cat(z.sat, x.dsat)),
+			cat(z.dsat, x.dsat),
+		}, nil
+
+	case "and_n":
+		if err := argsNeeded(2); err != nil {
+			return witnesses{}, err
+		}
+		x, y := args[0], args[1]
+		return witnesses{cat(y.sat, x.sat), x.dsat}, nil
+
+	case "and_v":
+		if err := argsNeeded(2); err != nil {
+			return witnesses{}, err
+		}
+		x, y := args[0], args[1]
+		return witnesses{cat(y.sat, x.sat), nil}, nil
+
+	case "and_b":
+		if err := argsNeeded(2); err != nil {
+			return witnesses{}, err
+		}
+		x, y := args[0], args[1]
+		return witnesses{cat(y.sat, x.sat), cat(y.dsat, x.dsat)}, nil
+
+	case "or_b":
+		if err := argsNeeded(2); err != nil {
+			return witnesses{}, err
+		}
+		x, z := args[0], args[1]
+		return witnesses{
+			either(cat(z.dsat, x.sat), cat(z.sat, x.dsat)),
+			cat(z.dsat, x.dsat),
+		}, nil
+
+	case "or_c":
+		if err := argsNeeded(2); err != nil {
+			return witnesses{}, err
+		}
+		x, z := args[0], args[1]
+		return witnesses{either(x.sat, cat(z.sat, x.dsat)), nil}, nil
+
+	case "or_d":
+		if err := argsNeeded(2); err != nil {
+			return witnesses{}, err
+		}
+		x, z := args[0], args[1]
+		return witnesses{
+			either(x.sat, cat(z.sat, x.dsat)),
+			cat(z.dsat, x.dsat),
+		}, nil
+
+	case "or_i":
+		if err := argsNeeded(2); err != nil {
+			return witnesses{}, err
+		}
+		x, z := args[0], args[1]
+		return witnesses{
+			either(cat(x.sat, w("01")), cat(z.sat, w("<>"))),
+			either(cat(x.dsat, w("01")), cat(z.dsat, w("<>"))),
+		}, nil
+
+	case "thresh":
+		k, err := strconv.Atoi(argValue(n.Args[0]))
+		if err != nil {
+			return witnesses{}, err
+		}
+
+		// The first sub-fragment is executed first, so its witness
+		// must be on top of the stack. We satisfy the first k
+		// sub-fragments that can be satisfied.
+		sat := []string{}
+		dsat := []string{}
+		satisfied := 0
+		for _, a := range args {
+			s := a.dsat
+			if satisfied < k && a.sat != nil {
+				s = a.sat
+				satisfied++
+			}
+
+			sat = cat(s, sat)
+			dsat = cat(a.dsat, dsat)
+		}
+
+		if satisfied < k {
+			sat = nil
+		}
+
+		return witnesses{sat, dsat}, nil
+
+	case "multi_a", "sortedmulti_a":
+		k, err := strconv.Atoi(argValue(n.Args[0]))
+		if err != nil {
+			return witnesses{}, err
+		}
+
+		var pubKeys []string
+		for _, a := range n.Args[1:] {
+			pubKeys = append(pubKeys, argValue(a))
+		}
+
+		if n.Fragment == "sortedmulti_a" {
+			pubKeys, err = sortKeys(pubKeys, keys)
+			if err != nil {
+				return witnesses{}, err
+			}
+		}
+
+		// The first key is checked first, so its signature must be
+		// on top of the stack.
+		var sat, dsat []string
+		for i, pk := range pubKeys {
+			el := "<>"
+			if i < k {
+				el = sigPlaceholder(pk)
+			}
+			sat = append(w(el), sat...)
+			dsat = append(dsat, "<>")
+		}
+
+		return witnesses{sat, dsat}, nil
+	}
+
+	return witnesses{}, fmt.Errorf("unknown fragment %s", n.Fragment)
+}
+
+// sortKeys sorts the key expressions by their x-only keys.
+func sortKeys(exprs []string, keys KeyFunc) ([]string, error) {
+	pubKeys := make(map[string]string)
+	for _, e := range exprs {
+		k, err := keys(e)
+		if err != nil {
+			return nil, err
+		}
+		pubKeys[e] = hex.EncodeToString(k)
+	}
+
+	sorted := append([]string{}, exprs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return pubKeys[sorted[i]] < pubKeys[sorted[j]]
+	})
+
+	return sorted, nil
+}
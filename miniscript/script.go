@@ -0,0 +1,455 @@
+package miniscript
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// KeyFunc returns the x-only public key for a key expression.
+type KeyFunc func(string) ([]byte, error)
+
+// HexKey parses a hex encoded x-only or compressed public key, returning the
+// x-only key.
+func HexKey(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", s, err)
+	}
+
+	switch {
+	case len(b) == 32:
+		return b, nil
+	case len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03):
+		return b[1:], nil
+	default:
+		return nil, fmt.Errorf("key %s: must be x-only or compressed "+
+			"public key", s)
+	}
+}
+
+// Compile parses the miniscript expression and returns its tapscript as
+// opcodes and hex encoded data pushes, in the format accepted by script.Parse.
+func Compile(expr string, keys KeyFunc) ([]string, error) {
+	n, err := Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.Script(keys)
+}
+
+// Script returns the tapscript of the node, as opcodes and hex encoded data
+// pushes.
+func (n *Node) Script(keys KeyFunc) ([]string, error) {
+	if n.Fragment == "" {
+		return nil, fmt.Errorf("%s is not a fragment", n.Value)
+	}
+
+	s, err := n.fragmentScript(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the wrappers, innermost first.
+	for i := len(n.Wrappers) - 1; i >= 0; i-- {
+		s, err = wrap(n.Wrappers[i], s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// fragmentScript returns the script of the fragment without wrappers.
+func (n *Node) fragmentScript(keys KeyFunc) ([]string, error) {
+	// sub returns the scripts of the fragment arguments, checking that
+	// the fragment has the expected number of arguments.
+	sub := func(num int) ([][]string, error) {
+		if len(n.Args) != num {
+			return nil, fmt.Errorf("%s takes %d arguments, got %d",
+				n.Fragment, num, len(n.Args))
+		}
+
+		var scripts [][]string
+		for _, a := range n.Args {
+			s, err := a.Script(keys)
+			if err != nil {
+				return nil, err
+			}
+			scripts = append(scripts, s)
+		}
+
+		return scripts, nil
+	}
+
+	// value returns the single value argument of the fragment.
+	value := func() (string, error) {
+		if len(n.Args) != 1 || argValue(n.Args[0]) == "" {
+			return "", fmt.Errorf("%s takes a single value argument",
+				n.Fragment)
+		}
+
+		return argValue(n.Args[0]), nil
+	}
+
+	// key returns the key given as the single argument.
+	key := func() ([]byte, error) {
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+
+		return keys(v)
+	}
+
+	// hashLock returns the script for a hash lock of the given size.
+	hashLock := func(op string, size int) ([]string, error) {
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+
+		h, err := hex.DecodeString(v)
+		if err != nil || len(h) != size {
+			return nil, fmt.Errorf("%s: invalid %d byte hash %s",
+				n.Fragment, size, v)
+		}
+
+		return []string{
+			"OP_SIZE", "20", "OP_EQUALVERIFY", op,
+			hex.EncodeToString(h), "OP_EQUAL",
+		}, nil
+	}
+
+	// timeLock returns the script for a time lock using the given
+	// opcode.
+	timeLock := func(op string) ([]string, error) {
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+
+		num, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || num < 1 || num >= 1<<31 {
+			return nil, fmt.Errorf("%s: invalid lock time %s",
+				n.Fragment, v)
+		}
+
+		return []string{Num(num), op}, nil
+	}
+
+	// threshold parses the threshold given as the first argument, which
+	// must be between 1 and the number of remaining arguments.
+	threshold := func() (int64, error) {
+		if len(n.Args) < 2 {
+			return 0, fmt.Errorf("%s needs a threshold and at "+
+				"least one argument", n.Fragment)
+		}
+
+		k, err := strconv.ParseInt(argValue(n.Args[0]), 10, 64)
+		if err != nil || k < 1 || k > int64(len(n.Args)-1) {
+			return 0, fmt.Errorf("%s: invalid threshold %s",
+				n.Fragment, argValue(n.Args[0]))
+		}
+
+		return k, nil
+	}
+
+	switch n.Fragment {
+	case "0":
+		return []string{"OP_0"}, nil
+
+	case "1":
+		return []string{"OP_1"}, nil
+
+	case "pk_k":
+		k, err := key()
+		if err != nil {
+			return nil, err
+		}
+		return []string{hex.EncodeToString(k)}, nil
+
+	case "pk_h":
+		k, err := key()
+		if err != nil {
+			return nil, err
+		}
+		return []string{
+			"OP_DUP", "OP_HASH160",
+			hex.EncodeToString(btcutil.Hash160(k)),
+			"OP_EQUALVERIFY",
+		}, nil
+
+	case "pk":
+		k, err := key()
+		if err != nil {
+			return nil, err
+		}
+		return []string{hex.EncodeToString(k), "OP_CHECKSIG"}, nil
+
+	case "pkh":
+		k, err := key()
+		if err != nil {
+			return nil, err
+		}
+		return []string{
+			"OP_DUP", "OP_HASH160",
+			hex.EncodeToString(btcutil.Hash160(k)),
+			"OP_EQUALVERIFY", "OP_CHECKSIG",
+		}, nil
+
+	case "older":
+		return timeLock("OP_CHECKSEQUENCEVERIFY")
+
+	case "after":
+		return timeLock("OP_CHECKLOCKTIMEVERIFY")
+
+	case "sha256":
+		return hashLock("OP_SHA256", 32)
+
+	case "hash256":
+		return hashLock("OP_HASH256", 32)
+
+	case "ripemd160":
+		return hashLock("OP_RIPEMD160", 20)
+
+	case "hash160":
+		return hashLock("OP_HASH160", 20)
+
+	case "andor":
+		s, err := sub(3)
+		if err != nil {
+			return nil, err
+		}
+		return concat(s[0], []string{"OP_NOTIF"}, s[2],
+			[]string{"OP_ELSE"}, s[1], []string{"OP_ENDIF"}), nil
+
+	case "and_v":
+		s, err := sub(2)
+		if err != nil {
+			return nil, err
+		}
+		return concat(s[0], s[1]), nil
+
+	case "and_b":
+		s, err := sub(2)
+		if err != nil {
+			return nil, err
+		}
+		return concat(s[0], s[1], []string{"OP_BOOLAND"}), nil
+
+	case "and_n":
+		s, err := sub(2)
+		if err != nil {
+			return nil, err
+		}
+		return concat(s[0], []string{"OP_NOTIF", "OP_0", "OP_ELSE"},
+			s[1], []string{"OP_ENDIF"}), nil
+
+	case "or_b":
+		s, err := sub(2)
+		if err != nil {
+			return nil, err
+		}
+		return concat(s[0], s[1], []string{"OP_BOOLOR"}), nil
+
+	case "or_c":
+		s, err := sub(2)
+		if err != nil {
+			return nil, err
+		}
+		return concat(s[0], []string{"OP_NOTIF"}, s[1],
+			[]string{"OP_ENDIF"}), nil
+
+	case "or_d":
+		s, err := sub(2)
+		if err != nil {
+			return nil, err
+		}
+		return concat(s[0], []string{"OP_IFDUP", "OP_NOTIF"}, s[1],
+			[]string{"OP_ENDIF"}), nil
+
+	case "or_i":
+		s, err := sub(2)
+		if err != nil {
+			return nil, err
+		}
+		return concat([]string{"OP_IF"}, s[0], []string{"OP_ELSE"},
+			s[1], []string{"OP_ENDIF"}), nil
+
+	case "thresh":
+		k, err := threshold()
+		if err != nil {
+			return nil, err
+		}
+
+		var script []string
+		for i, a := range n.Args[1:] {
+			s, err := a.Script(keys)
+			if err != nil {
+				return nil, err
+			}
+
+			script = append(script, s...)
+			if i > 0 {
+				script = append(script, "OP_ADD")
+			}
+		}
+		return append(script, Num(k), "OP_EQUAL"), nil
+
+	case "multi_a", "sortedmulti_a":
+		k, err := threshold()
+		if err != nil {
+			return nil, err
+		}
+
+		var pubKeys []string
+		for _, a := range n.Args[1:] {
+			pk, err := keys(argValue(a))
+			if err != nil {
+				return nil, err
+			}
+			pubKeys = append(pubKeys, hex.EncodeToString(pk))
+		}
+
+		if n.Fragment == "sortedmulti_a" {
+			// Hex encoded keys sort the same as their bytes.
+			sort.Strings(pubKeys)
+		}
+
+		var script []string
+		for i, pk := range pubKeys {
+			op := "OP_CHECKSIGADD"
+			if i == 0 {
+				op = "OP_CHECKSIG"
+			}
+			script = append(script, pk, op)
+		}
+		return append(script, Num(k), "OP_NUMEQUAL"), nil
+
+	case "multi", "sortedmulti":
+		return nil, fmt.Errorf("%s is not available in tapscript, use "+
+			"%s_a", n.Fragment, n.Fragment)
+
+	default:
+		return nil, fmt.Errorf("unknown fragment %s", n.Fragment)
+	}
+}
+
+// wrap applies the wrapper to the script.
+func wrap(w byte, s []string) ([]string, error) {
+	switch w {
+	case 'a':
+		return concat([]string{"OP_TOALTSTACK"}, s,
+			[]string{"OP_FROMALTSTACK"}), nil
+	case 's':
+		return concat([]string{"OP_SWAP"}, s), nil
+	case 'c':
+		return concat(s, []string{"OP_CHECKSIG"}), nil
+	case 't':
+		return concat(s, []string{"OP_1"}), nil
+	case 'd':
+		return concat([]string{"OP_DUP", "OP_IF"}, s,
+			[]string{"OP_ENDIF"}), nil
+	case 'v':
+		return verify(s), nil
+	case 'j':
+		return concat([]string{"OP_SIZE", "OP_0NOTEQUAL", "OP_IF"}, s,
+			[]string{"OP_ENDIF"}), nil
+	case 'n':
+		return concat(s, []string{"OP_0NOTEQUAL"}), nil
+	case 'l':
+		return concat([]string{"OP_IF", "OP_0", "OP_ELSE"}, s,
+			[]string{"OP_ENDIF"}), nil
+	case 'u':
+		return concat([]string{"OP_IF"}, s,
+			[]string{"OP_ELSE", "OP_0", "OP_ENDIF"}), nil
+	}
+
+	// Wrappers are checked during parsing, so this shouldn't happen.
+	return nil, fmt.Errorf("unknown wrapper %c", w)
+}
+
+// verify applies the v: wrapper, merging the final opcode with OP_VERIFY if
+// a VERIFY version of it exists.
+func verify(s []string) []string {
+	verifyOps := map[string]string{
+		"OP_EQUAL":    "OP_EQUALVERIFY",
+		"OP_NUMEQUAL": "OP_NUMEQUALVERIFY",
+		"OP_CHECKSIG": "OP_CHECKSIGVERIFY",
+	}
+
+	last := len(s) - 1
+	if v, ok := verifyOps[s[last]]; ok {
+		return concat(s[:last], []string{v})
+	}
+
+	return concat(s, []string{"OP_VERIFY"})
+}
+
+// Num returns the minimal push of the number, either as a small integer
+// opcode or as a hex encoded CScriptNum.
+func Num(n int64) string {
+	switch {
+	case n == 0:
+		return "OP_0"
+	case n >= 1 && n <= 16:
+		return fmt.Sprintf("OP_%d", n)
+	}
+
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+
+	var b []byte
+	for n > 0 {
+		b = append(b, byte(n&0xff))
+		n >>= 8
+	}
+
+	// If the most significant byte has the sign bit set, we need an
+	// extra byte for the sign.
+	if b[len(b)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if neg {
+			extra = 0x80
+		}
+		b = append(b, extra)
+	} else if neg {
+		b[len(b)-1] |= 0x80
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// argValue returns the value of an argument, which might have been parsed
+// as the 0 or 1 fragment.
+func argValue(n *Node) string {
+	if n.Fragment != "" && n.Wrappers == "" && len(n.Args) == 0 {
+		return n.Fragment
+	}
+
+	return n.Value
+}
+
+// concat concatenates the scripts into a new slice.
+func concat(scripts ...[]string) []string {
+	var s []string
+	for _, script := range scripts {
+		s = append(s, script...)
+	}
+
+	return s
+}
+
+// String returns the script as a single string.
+func String(tokens []string) string {
+	return strings.Join(tokens, " ")
+}
@@ -0,0 +1,174 @@
+package output
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+)
+
+// Diff describes how a stack element changed compared to the previous
+// execution step.
+type Diff int
+
+const (
+	// DiffNone means the element is unchanged since the previous step.
+	DiffNone Diff = iota
+
+	// DiffPushed means the element was pushed during the last step.
+	DiffPushed
+
+	// DiffPopped means the element was removed during the last step. It
+	// is no longer on the stack, but is kept around for display.
+	DiffPopped
+
+	// DiffModified means a different value now occupies this position on
+	// the stack.
+	DiffModified
+)
+
+// String returns a human-readable name of the diff kind.
+func (d Diff) String() string {
+	switch d {
+	case DiffPushed:
+		return "pushed"
+	case DiffPopped:
+		return "popped"
+	case DiffModified:
+		return "modified"
+	default:
+		return "unchanged"
+	}
+}
+
+// MarshalText encodes the diff kind by its name, such that it shows up
+// readable in the JSON trace.
+func (d Diff) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Marker returns the single character used to mark the diff kind in plain
+// (non-colored) mode.
+func (d Diff) Marker() string {
+	switch d {
+	case DiffPushed:
+		return "+"
+	case DiffPopped:
+		return "-"
+	case DiffModified:
+		return "~"
+	default:
+		return " "
+	}
+}
+
+// StackElement is a single stack element as displayed, annotated with how it
+// changed since the previous step.
+type StackElement struct {
+	Value string `json:"value"`
+	Diff  Diff   `json:"diff"`
+}
+
+// StackDiff compares the stack at the previous step with the current one,
+// position by position from the bottom of the stack. The returned elements
+// are ordered top first, like StackToString. Elements popped since the
+// previous step are included above the current top of the stack.
+func StackDiff(prev, cur [][]byte) []StackElement {
+	n := len(cur)
+	if len(prev) > n {
+		n = len(prev)
+	}
+
+	elements := make([]StackElement, 0, n)
+	for i := n - 1; i >= 0; i-- {
+		switch {
+		case i >= len(cur):
+			elements = append(elements, StackElement{
+				Value: elementString(prev[i]),
+				Diff:  DiffPopped,
+			})
+
+		case i >= len(prev):
+			elements = append(elements, StackElement{
+				Value: elementString(cur[i]),
+				Diff:  DiffPushed,
+			})
+
+		case !bytes.Equal(prev[i], cur[i]):
+			elements = append(elements, StackElement{
+				Value: elementString(cur[i]),
+				Diff:  DiffModified,
+			})
+
+		default:
+			elements = append(elements, StackElement{
+				Value: elementString(cur[i]),
+			})
+		}
+	}
+
+	return elements
+}
+
+// elementString returns the string representation of a single stack element.
+// The empty element is treated as 0.
+func elementString(b []byte) string {
+	if len(b) == 0 {
+		return "<>"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// ColorSupported returns whether we should use color codes when writing to
+// stdout. Colors are disabled if stdout is not a terminal, or if the user has
+// set NO_COLOR or TERM=dumb.
+func ColorSupported() bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+const (
+	colorReset  = "\u001B[0m"
+	colorRed    = "\u001B[31m"
+	colorGreen  = "\u001B[32m"
+	colorYellow = "\u001B[33m"
+)
+
+// colorize wraps the already formatted cell in the color code matching the
+// diff kind.
+func colorize(d Diff, s string) string {
+	switch d {
+	case DiffPushed:
+		return colorGreen + s + colorReset
+	case DiffPopped:
+		return colorRed + s + colorReset
+	case DiffModified:
+		return colorYellow + s + colorReset
+	default:
+		return s
+	}
+}
+
+// StepState is the VM state at a single execution step, in a form suitable
+// for the JSON trace.
+type StepState struct {
+	Step        int            `json:"step"`
+	ScriptIndex int            `json:"script_index"`
+	ScriptName  string         `json:"script_name"`
+	OpcodeIndex int            `json:"opcode_index"`
+	Opcode      string         `json:"opcode,omitempty"`
+	Stack       []StackElement `json:"stack"`
+	AltStack    []StackElement `json:"alt_stack"`
+}
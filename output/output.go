@@ -1,7 +1,6 @@
 package output
 
 import (
-	"encoding/hex"
 	"fmt"
 	"strings"
 
@@ -13,20 +12,17 @@ const remStr = "......."
 var (
 	ColumnWidth = 40
 	MaxRows     = 25
+
+	// Color determines whether stack changes are highlighted using
+	// terminal colors. If false, they are marked by a leading character
+	// instead.
+	Color = false
 )
 
 func StackToString(stack [][]byte) []string {
 	var str []string
 	for i := len(stack) - 1; i >= 0; i-- {
-		b := stack[i]
-
-		// The empty element is treated as 0.
-		if len(b) == 0 {
-			str = append(str, "<>")
-			continue
-		}
-		s := hex.EncodeToString(b)
-		str = append(str, s)
+		str = append(str, elementString(stack[i]))
 	}
 
 	return str
@@ -65,8 +61,8 @@ func WitnessToString(witness [][]byte) []string {
 	return str
 }
 
-func ExecutionTable(pc int, script, stack, altStack, witness []string,
-	tags map[string]string) string {
+func ExecutionTable(pc int, script []string, stack, altStack []StackElement,
+	witness []string, tags map[string]string) string {
 
 	fullWidth := 4 * (ColumnWidth + 2)
 	s := strings.Repeat("-", fullWidth)
@@ -80,7 +76,9 @@ func ExecutionTable(pc int, script, stack, altStack, witness []string,
 	s += strings.Repeat("-", fullWidth)
 	s += "\n"
 
-	// In case script is too long, trim it.
+	// In case script is too long, trim it. We work on a copy, to not
+	// modify the caller's slice.
+	script = append([]string(nil), script...)
 	for len(script) > MaxRows {
 		if pc <= len(script)/2 {
 			if script[len(script)-1] == remStr {
@@ -97,20 +95,9 @@ func ExecutionTable(pc int, script, stack, altStack, witness []string,
 	}
 
 	// We trim the stack as well, but only from the bottom.
-	trimStack := func(stack []string) []string {
-		for len(stack) > MaxRows {
-			if stack[len(stack)-1] == remStr {
-				stack = stack[:len(stack)-1]
-			}
-			stack[len(stack)-1] = remStr
-		}
-
-		return stack
-	}
-
-	witness = trimStack(witness)
-	stack = trimStack(stack)
-	altStack = trimStack(altStack)
+	witness = trimStack(witness, remStr)
+	stack = trimStack(stack, StackElement{Value: remStr})
+	altStack = trimStack(altStack, StackElement{Value: remStr})
 
 	row := 0
 	for {
@@ -119,12 +106,11 @@ func ExecutionTable(pc int, script, stack, altStack, witness []string,
 			scr = script[row]
 		}
 
-		stk := ""
+		var stk, alt StackElement
 		if row < len(stack) {
 			stk = stack[row]
 		}
 
-		alt := ""
 		if row < len(altStack) {
 			alt = altStack[row]
 		}
@@ -140,15 +126,15 @@ func ExecutionTable(pc int, script, stack, altStack, witness []string,
 
 		}
 
-		s += fmt.Sprintf("%s%s| %s| %s| %s\n",
+		s += fmt.Sprintf("%s%s|%s|%s| %s\n",
 			pcC,
 			FixedWidth(ColumnWidth, scr, tags),
-			FixedWidth(ColumnWidth, stk, tags),
-			FixedWidth(ColumnWidth, alt, tags),
+			stackCell(stk, tags),
+			stackCell(alt, tags),
 			FixedWidth(ColumnWidth, wit, tags),
 		)
 
-		if scr == "" && stk == "" && alt == "" && wit == "" {
+		if scr == "" && stk.Value == "" && alt.Value == "" && wit == "" {
 			break
 		}
 
@@ -161,6 +147,36 @@ func ExecutionTable(pc int, script, stack, altStack, witness []string,
 	return s
 }
 
+// trimStack trims the rows from the bottom until they fit within MaxRows,
+// replacing the last row with rem.
+func trimStack[T comparable](stack []T, rem T) []T {
+	if len(stack) <= MaxRows {
+		return stack
+	}
+
+	stack = append([]T(nil), stack...)
+	for len(stack) > MaxRows {
+		if stack[len(stack)-1] == rem {
+			stack = stack[:len(stack)-1]
+		}
+		stack[len(stack)-1] = rem
+	}
+
+	return stack
+}
+
+// stackCell formats a stack element to fit its column, including the leading
+// space. Changed elements are either colored, or have the leading space
+// replaced by a marker.
+func stackCell(e StackElement, tags map[string]string) string {
+	cell := FixedWidth(ColumnWidth, e.Value, tags)
+	if Color {
+		return " " + colorize(e.Diff, cell)
+	}
+
+	return e.Diff.Marker() + cell
+}
+
 func FixedWidth(w int, s string, tags map[string]string) string {
 	// If there's a tag, we want to show that at the end, always.
 	tagSuffix := ""
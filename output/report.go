@@ -0,0 +1,184 @@
+package output
+
+import (
+	"html/template"
+	"io"
+)
+
+// ReportStep is the state at a single execution step to include in the HTML
+// report.
+type ReportStep struct {
+	StepState
+
+	// Script is the script currently executing, one opcode per element.
+	Script []string `json:"script"`
+
+	// Witness is the witness stack, if shown at this step.
+	Witness []string `json:"witness"`
+}
+
+// reportData is the data passed to the report template.
+type reportData struct {
+	Steps []ReportStep
+	Tags  map[string]string
+	Error string
+}
+
+// WriteReport writes a self-contained HTML report of the given execution
+// steps to w. If execErr is non-nil, it is shown in a failure banner. The
+// report embeds everything it needs, such that it can be viewed offline.
+func WriteReport(w io.Writer, steps []ReportStep, tags map[string]string,
+	execErr error) error {
+
+	data := reportData{
+		Steps: steps,
+		Tags:  tags,
+	}
+	if data.Steps == nil {
+		data.Steps = []ReportStep{}
+	}
+	if data.Tags == nil {
+		data.Tags = map[string]string{}
+	}
+	if execErr != nil {
+		data.Error = execErr.Error()
+	}
+
+	return reportTemplate.Execute(w, data)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>tapsim execution report</title>
+<style>
+body { font-family: monospace; margin: 1em; background: #fdfdfd; }
+.banner { padding: 0.6em 1em; margin-bottom: 1em; font-weight: bold; }
+.fail { background: #f8d7da; color: #721c24; border: 1px solid #f5c6cb; }
+.ok { background: #d4edda; color: #155724; border: 1px solid #c3e6cb; }
+.nav { margin-bottom: 1em; }
+table { border-collapse: collapse; table-layout: fixed; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; vertical-align: top; }
+th { background: #eee; text-align: left; }
+td div { overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+td div:hover { white-space: normal; word-break: break-all; background: #ffffe0; }
+.pc { background: #dbe9ff; font-weight: bold; }
+.pushed { color: #1a7f37; }
+.popped { color: #cf222e; text-decoration: line-through; }
+.modified { color: #9a6700; }
+.tag { color: #6f42c1; }
+</style>
+</head>
+<body>
+{{if .Error}}<div class="banner fail">Script execution failed: {{.Error}}</div>{{else}}<div class="banner ok">Script execution verified</div>{{end}}
+<div class="nav">
+<button id="prev">&larr; back</button>
+<span id="counter"></span>
+<button id="next">next &rarr;</button>
+<span>(use the arrow keys to step)</span>
+</div>
+<table>
+<thead><tr><th>script</th><th>stack</th><th>alt stack</th><th>witness</th></tr></thead>
+<tbody id="rows"></tbody>
+</table>
+<script>
+const steps = {{.Steps}};
+const tags = {{.Tags}};
+const failed = {{if .Error}}true{{else}}false{{end}};
+let current = 0;
+
+function cell(value, cls) {
+	const td = document.createElement("td");
+	if (value === undefined) {
+		return td;
+	}
+	const div = document.createElement("div");
+	div.textContent = value;
+	div.title = value;
+	if (cls) {
+		div.className = cls;
+	}
+	if (tags[value] !== undefined) {
+		const tag = document.createElement("span");
+		tag.className = "tag";
+		tag.textContent = " (" + tags[value] + ")";
+		div.appendChild(tag);
+	}
+	td.appendChild(div);
+	return td;
+}
+
+function render() {
+	const step = steps[current];
+	const rows = document.getElementById("rows");
+	rows.innerHTML = "";
+	if (step === undefined) {
+		document.getElementById("counter").textContent = "no steps";
+		return;
+	}
+
+	let counter = "step " + (current + 1) + "/" + steps.length;
+	if (failed && current === steps.length - 1) {
+		counter += " (failed after this step)";
+	}
+	document.getElementById("counter").textContent = counter;
+
+	const script = step.script || [];
+	const stack = step.stack || [];
+	const alt = step.alt_stack || [];
+	const witness = step.witness || [];
+	const n = Math.max(script.length + 1, stack.length, alt.length,
+		witness.length);
+
+	for (let i = 0; i < n; i++) {
+		const tr = document.createElement("tr");
+		const scr = cell(script[i]);
+		if (i === step.opcode_index) {
+			scr.className = "pc";
+			if (script[i] === undefined) {
+				scr.textContent = "▶";
+			}
+		}
+		tr.appendChild(scr);
+
+		const s = stack[i];
+		tr.appendChild(s ? cell(s.value, s.diff) : cell());
+		const a = alt[i];
+		tr.appendChild(a ? cell(a.value, a.diff) : cell());
+		tr.appendChild(cell(witness[i]));
+		rows.appendChild(tr);
+	}
+}
+
+function step(delta) {
+	current = Math.min(Math.max(current + delta, 0), steps.length - 1);
+	render();
+}
+
+document.getElementById("prev").onclick = function() { step(-1); };
+document.getElementById("next").onclick = function() { step(1); };
+document.addEventListener("keydown", function(e) {
+	switch (e.key) {
+	case "ArrowRight":
+		step(1);
+		break;
+	case "ArrowLeft":
+		step(-1);
+		break;
+	case "Home":
+		current = 0;
+		render();
+		break;
+	case "End":
+		current = steps.length - 1;
+		render();
+		break;
+	}
+});
+
+render();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,134 @@
+package script
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// TapBranchStep is a single step up the taptree when verifying a control
+// block.
+type TapBranchStep struct {
+	// Node is the hash of the node we are at, starting with the leaf.
+	Node chainhash.Hash
+
+	// Sibling is the hash of the sibling given in the control block.
+	Sibling chainhash.Hash
+
+	// Branch is the TapBranch hash of the node and its sibling.
+	Branch chainhash.Hash
+}
+
+// ControlBlockInfo is the decoded control block, together with the taptree
+// hashes computed from it and the leaf script.
+type ControlBlockInfo struct {
+	*txscript.ControlBlock
+
+	// LeafHash is the TapLeaf hash of the leaf script.
+	LeafHash chainhash.Hash
+
+	// Steps are the steps from the leaf up to the root of the taptree,
+	// one for each hash in the inclusion proof.
+	Steps []TapBranchStep
+
+	// MerkleRoot is the root of the taptree.
+	MerkleRoot chainhash.Hash
+
+	// OutputKey is the taproot output key the control block and leaf
+	// script commit to.
+	OutputKey *btcec.PublicKey
+}
+
+// ComputedYIsOdd returns whether the computed output key has an odd y
+// coordinate, which must match the parity bit of the control block.
+func (c *ControlBlockInfo) ComputedYIsOdd() bool {
+	// Compressed keys with odd y coordinate have the prefix 0x03.
+	return c.OutputKey.SerializeCompressed()[0] == 0x03
+}
+
+// InspectControlBlock decodes the control block and computes the taptree
+// hashes from the leaf script up to the output key.
+func InspectControlBlock(ctrlBlock, leafScript []byte) (*ControlBlockInfo,
+	error) {
+
+	cb, err := txscript.ParseControlBlock(ctrlBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ControlBlockInfo{
+		ControlBlock: cb,
+		LeafHash: txscript.NewTapLeaf(
+			cb.LeafVersion, leafScript,
+		).TapHash(),
+	}
+
+	node := info.LeafHash
+	for i := 0; i < len(cb.InclusionProof); i += 32 {
+		var sibling chainhash.Hash
+		copy(sibling[:], cb.InclusionProof[i:i+32])
+
+		// Children are sorted lexicographically before hashing.
+		left, right := node, sibling
+		if bytes.Compare(left[:], right[:]) > 0 {
+			left, right = right, left
+		}
+
+		branch := chainhash.TaggedHash(
+			chainhash.TagTapBranch, left[:], right[:],
+		)
+
+		info.Steps = append(info.Steps, TapBranchStep{
+			Node:    node,
+			Sibling: sibling,
+			Branch:  *branch,
+		})
+		node = *branch
+	}
+
+	info.MerkleRoot = node
+	info.OutputKey = txscript.ComputeTaprootOutputKey(
+		cb.InternalKey, info.MerkleRoot[:],
+	)
+
+	return info, nil
+}
+
+// VerifyOutputKey checks that the control block commits to the given x-only
+// output key, returning an error describing the mismatch if not.
+func (c *ControlBlockInfo) VerifyOutputKey(outputKey []byte) error {
+	computed := schnorr.SerializePubKey(c.OutputKey)
+	if !bytes.Equal(computed, outputKey) {
+		return fmt.Errorf("output key mismatch: computed %x, "+
+			"expected %x", computed, outputKey)
+	}
+
+	if c.ComputedYIsOdd() != c.ControlBlock.OutputKeyYIsOdd {
+		return fmt.Errorf("parity bit mismatch: control block has "+
+			"odd=%v, output key has odd=%v",
+			c.ControlBlock.OutputKeyYIsOdd, c.ComputedYIsOdd())
+	}
+
+	return nil
+}
+
+// TapscriptSpend returns the leaf script and control block from the witness
+// of a taproot script path spend, skipping any annex.
+func TapscriptSpend(witness [][]byte) ([]byte, []byte, error) {
+	if len(witness) >= 2 && len(witness[len(witness)-1]) > 0 &&
+		witness[len(witness)-1][0] == txscript.TaprootAnnexTag {
+
+		witness = witness[:len(witness)-1]
+	}
+
+	if len(witness) < 2 {
+		return nil, nil, fmt.Errorf("witness is not a script path " +
+			"spend")
+	}
+
+	return witness[len(witness)-2], witness[len(witness)-1], nil
+}
@@ -2,34 +2,138 @@ package script
 
 import (
 	"fmt"
-	"strings"
+	"io"
+	"os"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/halseth/tapsim/address"
+	"github.com/halseth/tapsim/descriptor"
 	"github.com/halseth/tapsim/output"
-	"github.com/pkg/term"
+	"github.com/halseth/tapsim/tweak"
 )
 
+// TxOutput is an output of the transaction built by BuildTx. It is a taproot
+// output to OutputKey, unless InternalKey or PkScript is set.
 type TxOutput struct {
 	OutputKey *btcec.PublicKey
 	Value     int64
+
+	// InternalKey, if set, is the naked internal key the output key is
+	// derived from, by embedding Data and committing to TapTree. If
+	// InputTapTree is set, the output commits to the taptree of the
+	// spent output instead. OutputKey is set by BuildTx in this case.
+	InternalKey  *btcec.PublicKey
+	Data         []byte
+	TapTree      *txscript.IndexedTapScriptTree
+	InputTapTree bool
+
+	// PkScript, if set, is the output script of a non-taproot output,
+	// like P2WPKH or OP_RETURN.
+	PkScript []byte
+}
+
+// TxInput is the previous output spent by the transaction built by BuildTx.
+// The output script is derived from the executed script.
+type TxInput struct {
+	OutPoint wire.OutPoint
+	Value    int64
 }
 
 const scriptFlags = txscript.StandardVerifyFlags | txscript.ScriptVerifyOpCat
 
-// Execute builds a tap leaf using the passed pkScript and executes it step by
-// step with the provided witness.
+// DefaultFee is a fee in sats above the minimum relay fee of a small
+// transaction, for callers deriving output values from the input value.
+// BuildTx itself pays no fee, since OP_CHECKCONTRACTVERIFY checks by default
+// that the output value is kept.
+const DefaultFee = 1000
+
+// Execute builds the transaction spending the script described by opts using
+// Build, and executes it step by step with the witness of opts.
+//
+// If trace is non-nil, the VM state at every step is written to it as JSON. If
+// report is non-nil, a HTML report of the execution is written to it.
+func Execute(opts *Options, interactive, noStep bool,
+	tags map[string]string, skipAhead int, trace, report io.Writer) error {
+
+	tx, prevOuts, err := Build(opts, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	return ExecuteTx(
+		tx, prevOuts, 0, interactive, noStep, tags, skipAhead, trace,
+		report,
+	)
+}
+
+// BuildTx builds and signs a transaction with a single input spending an
+// output committing to the passed pkScript, using the provided witness. It
+// returns the transaction together with the prevout it spends. By default
+// the script is committed to as a tap leaf, other script types place the
+// witness elements in the witness or scriptSig as appropriate for the type.
 //
 // privKeyBytes should map names of private keys given in the input witness to
 // key bytes. An empty key will generate a random one.
 //
 // If [input/output]KeyBytes is empty, a random key will be generated.
-func Execute(privKeyBytes map[string][]byte, inputKeyBytes []byte,
-	outputs []TxOutput, pkScripts [][]byte, scriptIndex int,
-	witnessGen []WitnessGen, interactive, noStep bool, tags map[string]string,
-	skipAhead int) error {
+//
+// For taproot, inputData is embedded in the input key before committing to
+// the taptree, the way OP_CHECKCONTRACTVERIFY checks it.
+//
+// For taproot, the pkScripts are assembled into a taptree, unless tapTree is
+// set. In that case the given tree is used, and pkScripts must be its leaf
+// scripts in the order they are indexed.
+//
+// Addresses are printed for the network given by chainParams, or mainnet if
+// nil.
+func BuildTx(privKeyBytes map[string][]byte, inputKeyBytes,
+	inputData []byte, input TxInput, outputs []TxOutput, pkScripts [][]byte,
+	tapTree *txscript.IndexedTapScriptTree, scriptIndex int,
+	scriptType ScriptType, witnessGen []WitnessGen,
+	chainParams *chaincfg.Params) (*wire.MsgTx, []*wire.TxOut, error) {
+
+	b, err := buildTx(
+		privKeyBytes, inputKeyBytes, inputData, input, outputs,
+		pkScripts, tapTree, scriptIndex, scriptType, witnessGen,
+		chainParams, os.Stdout,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b.tx, b.prevOuts, nil
+}
+
+// builtTx is a transaction built by buildTx, together with the keys of the
+// outputs it spends and creates.
+type builtTx struct {
+	tx       *wire.MsgTx
+	prevOuts []*wire.TxOut
+
+	// inputKeys are the keys of the spent output, nil if it is not a
+	// taproot output.
+	inputKeys *tweak.Keys
+
+	// outputKeys are the keys of the outputs, nil for outputs that are
+	// not taproot outputs.
+	outputKeys []*tweak.Keys
+}
+
+// buildTx builds the transaction like BuildTx, writing the keys and scripts
+// it derives to w.
+func buildTx(privKeyBytes map[string][]byte, inputKeyBytes,
+	inputData []byte, input TxInput, outputs []TxOutput, pkScripts [][]byte,
+	tapTree *txscript.IndexedTapScriptTree, scriptIndex int,
+	scriptType ScriptType, witnessGen []WitnessGen,
+	chainParams *chaincfg.Params, w io.Writer) (*builtTx, error) {
+
+	if chainParams == nil {
+		chainParams = &chaincfg.MainNetParams
+	}
 
 	// Parse the input private keys.
 	privKeys := make(map[string]*btcec.PrivateKey)
@@ -42,7 +146,7 @@ func Execute(privKeyBytes map[string][]byte, inputKeyBytes []byte,
 		if len(v) == 0 {
 			key, err = btcec.NewPrivateKey()
 			if err != nil {
-				return err
+				return nil, err
 			}
 		} else {
 			key, _ = btcec.PrivKeyFromBytes(v)
@@ -54,7 +158,7 @@ func Execute(privKeyBytes map[string][]byte, inputKeyBytes []byte,
 	if len(inputKeyBytes) == 0 {
 		privKey, err := btcec.NewPrivateKey()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		inputKey = privKey.PubKey()
@@ -62,75 +166,159 @@ func Execute(privKeyBytes map[string][]byte, inputKeyBytes []byte,
 		var err error
 		inputKey, err = schnorr.ParsePubKey(inputKeyBytes)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	if len(outputs) == 0 {
 		privKey, err := btcec.NewPrivateKey()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		outputKey := privKey.PubKey()
-		outputs = append(outputs, TxOutput{outputKey, 1e8})
+		outputs = append(outputs, TxOutput{
+			OutputKey: privKey.PubKey(),
+			Value:     input.Value,
+		})
 	}
 
-	var tapLeaves []txscript.TapLeaf
-	var tapLeaf txscript.TapLeaf
-	for i, pkScript := range pkScripts {
-		t := txscript.NewBaseTapLeaf(pkScript)
-		tapLeaves = append(tapLeaves, t)
+	if scriptType != ScriptTypeP2TR && len(pkScripts) > 1 {
+		return nil, fmt.Errorf("multiple scripts only supported "+
+			"for %v", ScriptTypeP2TR)
+	}
 
-		if i == scriptIndex {
-			tapLeaf = t
-		}
+	if scriptType != ScriptTypeP2TR && tapTree != nil {
+		return nil, fmt.Errorf("taptree only supported for %v",
+			ScriptTypeP2TR)
 	}
 
-	tapScriptTree := txscript.AssembleTaprootScriptTree(tapLeaves...)
+	if scriptType != ScriptTypeP2TR && len(inputData) != 0 {
+		return nil, fmt.Errorf("input data only supported for %v",
+			ScriptTypeP2TR)
+	}
 
-	ctrlBlock := tapScriptTree.LeafMerkleProofs[scriptIndex].ToControlBlock(
-		inputKey,
+	// Create the output script committing to the script we are going to
+	// execute, depending on the script type.
+	pkScript := pkScripts[scriptIndex]
+	var (
+		inputScript    []byte
+		redeemScript   []byte
+		tapLeaf        txscript.TapLeaf
+		tapScriptTree  *txscript.IndexedTapScriptTree
+		inputKeys      *tweak.Keys
+		ctrlBlockBytes []byte
+		err            error
 	)
+	switch scriptType {
+	case ScriptTypeP2TR:
+		tapScriptTree = tapTree
+		if tapScriptTree == nil {
+			var tapLeaves []txscript.TapLeaf
+			for _, pkScript := range pkScripts {
+				tapLeaves = append(
+					tapLeaves, txscript.NewBaseTapLeaf(pkScript),
+				)
+			}
 
-	tapScriptRootHash := tapScriptTree.RootNode.TapHash()
+			tapScriptTree = txscript.AssembleTaprootScriptTree(
+				tapLeaves...,
+			)
+		}
 
-	inputTapKey := txscript.ComputeTaprootOutputKey(
-		inputKey, tapScriptRootHash[:],
-	)
+		tapLeaf = tapScriptTree.LeafMerkleProofs[scriptIndex].TapLeaf
 
-	inputScript, err := txscript.PayToTaprootScript(inputTapKey)
+		// Embed the data in the input key, and commit to the
+		// taptree.
+		keys := tweak.Compute(inputKey, inputData, tapScriptTree)
+		inputKeys = keys
+
+		ctrlBlock := tapScriptTree.LeafMerkleProofs[scriptIndex].ToControlBlock(
+			keys.InternalKey,
+		)
+
+		inputTapKey := keys.OutputKey
+
+		inputScript, err = txscript.PayToTaprootScript(inputTapKey)
+		if err != nil {
+			return nil, err
+		}
+
+		ctrlBlockBytes, err = ctrlBlock.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+
+		desc, standard, err := descriptor.Taproot(
+			keys.InternalKey, tapScriptTree.RootNode,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		addr, err := address.Taproot(inputTapKey, chainParams)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(w, "taptree: %x\n", keys.TapRoot)
+		if len(inputData) != 0 {
+			fmt.Fprintf(w, "input naked key: %x\n", schnorr.SerializePubKey(inputKey))
+			fmt.Fprintf(w, "input data: %x\n", inputData)
+		}
+		fmt.Fprintf(w, "input internal key: %x\n", schnorr.SerializePubKey(keys.InternalKey))
+		fmt.Fprintf(w, "input taproot key: %x\n", schnorr.SerializePubKey(inputTapKey))
+		fmt.Fprintf(w, "input %s: %s\n", descriptorName(standard), desc)
+		fmt.Fprintf(w, "input address: %s\n", addr)
+
+	case ScriptTypeP2WSH:
+		inputScript, err = p2wshScript(pkScript)
+
+	case ScriptTypeP2SH:
+		inputScript, err = p2shScript(pkScript)
+
+	case ScriptTypeP2SHP2WSH:
+		redeemScript, err = p2wshScript(pkScript)
+		if err != nil {
+			return nil, err
+		}
+		inputScript, err = p2shScript(redeemScript)
+
+	case ScriptTypeLegacy:
+		inputScript = pkScript
+
+	default:
+		return nil, fmt.Errorf("unknown script type %v",
+			scriptType)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Printf("taptree: %x\n", tapScriptRootHash[:])
-	fmt.Printf("input internal key: %x\n", schnorr.SerializePubKey(inputKey))
-	fmt.Printf("input taproot key: %x\n", schnorr.SerializePubKey(inputTapKey))
+	if scriptType != ScriptTypeP2TR {
+		fmt.Fprintf(w, "input %v script: %x\n", scriptType, inputScript)
+		if addr := address.FromPkScript(inputScript, chainParams); addr != "" {
+			fmt.Fprintf(w, "input address: %s\n", addr)
+		}
+	}
 
 	prevOut := &wire.TxOut{
-		Value:    1e8,
+		Value:    input.Value,
 		PkScript: inputScript,
 	}
-
-	op := wire.OutPoint{
-		Index: 0,
-	}
 	prevOuts := []*wire.TxOut{prevOut}
 
 	tx := wire.NewMsgTx(2)
 	tx.AddTxIn(&wire.TxIn{
-		PreviousOutPoint: op,
+		PreviousOutPoint: input.OutPoint,
 	})
 
+	var outputKeys []*tweak.Keys
 	for i, o := range outputs {
-		fmt.Printf("output[%d] taproot key: %x:%d\n",
-			i, schnorr.SerializePubKey(o.OutputKey), o.Value)
-
-		outputScript, err := txscript.PayToTaprootScript(o.OutputKey)
+		outputScript, keys, err := outputScript(w, i, o, tapScriptTree, chainParams)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		outputKeys = append(outputKeys, keys)
 
 		tx.AddTxOut(&wire.TxOut{
 			Value:    o.Value,
@@ -148,269 +336,376 @@ func Execute(privKeyBytes map[string][]byte, inputKeyBytes []byte,
 		if !ok {
 			return nil, fmt.Errorf("private key %s not known", keyID)
 		}
-		return txscript.RawTxInTapscriptSignature(
-			tx, sigHashes, 0, prevOut.Value, prevOut.PkScript, tapLeaf,
-			txscript.SigHashDefault, privKey,
-		)
+
+		switch scriptType {
+		case ScriptTypeP2TR:
+			return txscript.RawTxInTapscriptSignature(
+				tx, sigHashes, 0, prevOut.Value,
+				prevOut.PkScript, tapLeaf,
+				txscript.SigHashDefault, privKey,
+			)
+
+		case ScriptTypeP2WSH, ScriptTypeP2SHP2WSH:
+			return txscript.RawTxInWitnessSignature(
+				tx, sigHashes, 0, prevOut.Value, pkScript,
+				txscript.SigHashAll, privKey,
+			)
+
+		default:
+			return txscript.RawTxInSignature(
+				tx, 0, pkScript, txscript.SigHashAll, privKey,
+			)
+		}
 	}
 
-	var combinedWitness wire.TxWitness
+	var elements [][]byte
 	for _, gen := range witnessGen {
 		w, err := gen(signFunc)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		combinedWitness = append(combinedWitness, w)
+		elements = append(elements, w)
 	}
 
-	ctrlBlockBytes, err := ctrlBlock.ToBytes()
+	// Place the elements in the witness or scriptSig, depending on the
+	// script type.
+	var (
+		combinedWitness wire.TxWitness
+		sigScript       []byte
+	)
+	switch scriptType {
+	case ScriptTypeP2TR:
+		combinedWitness = append(elements, pkScript, ctrlBlockBytes)
+
+	case ScriptTypeP2WSH:
+		combinedWitness = append(elements, pkScript)
+
+	case ScriptTypeP2SHP2WSH:
+		combinedWitness = append(elements, pkScript)
+		sigScript, err = pushScript(redeemScript)
+
+	case ScriptTypeP2SH:
+		sigScript, err = pushScript(append(elements, pkScript)...)
+
+	case ScriptTypeLegacy:
+		sigScript, err = pushScript(elements...)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	combinedWitness = append(combinedWitness, pkScripts[scriptIndex], ctrlBlockBytes)
-
 	txCopy := tx.Copy()
 	txCopy.TxIn[0].Witness = combinedWitness
-
-	return ExecuteTx(txCopy, prevOuts, 0, interactive, noStep, tags, skipAhead)
+	txCopy.TxIn[0].SignatureScript = sigScript
+
+	return &builtTx{
+		tx:         txCopy,
+		prevOuts:   prevOuts,
+		inputKeys:  inputKeys,
+		outputKeys: outputKeys,
+	}, nil
 }
 
-func ExecuteTx(tx *wire.MsgTx, prevOuts []*wire.TxOut, txIdx int,
-	interactive, noStep bool, tags map[string]string, skipAhead int) error {
+// outputScript returns the output script of the output and its keys, writing
+// the keys it is derived from to w. inputTree is the taptree of the spent
+// output, if any. The keys are nil if the output is not a taproot output, and
+// only have the output key set if it was given directly. Addresses are written
+// for the network given by chainParams.
+func outputScript(w io.Writer, i int, o TxOutput,
+	inputTree *txscript.IndexedTapScriptTree,
+	chainParams *chaincfg.Params) ([]byte, *tweak.Keys, error) {
+
+	if o.PkScript != nil {
+		fmt.Fprintf(w, "output[%d] script: %x:%d\n", i, o.PkScript, o.Value)
+		if addr := address.FromPkScript(o.PkScript, chainParams); addr != "" {
+			fmt.Fprintf(w, "output[%d] address: %s\n", i, addr)
+		}
 
-	prevMap := make(map[wire.OutPoint]*wire.TxOut)
-	for i, in := range tx.TxIn {
-		prevMap[in.PreviousOutPoint] = prevOuts[i]
+		return o.PkScript, nil, nil
 	}
 
-	prevOutFetcher := txscript.NewMultiPrevOutFetcher(prevMap)
-	currentInput := prevOuts[txIdx]
+	// Derive the output key from the internal key, data and taptree.
+	keys := &tweak.Keys{OutputKey: o.OutputKey}
+	if o.InternalKey != nil {
+		tree := o.TapTree
+		if o.InputTapTree {
+			tree = inputTree
+		}
 
-	setupFunc := func(cb func(*txscript.StepInfo) error) (*txscript.Engine, error) {
-		sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
-		return txscript.NewDebugEngine(
-			currentInput.PkScript, tx, txIdx, scriptFlags,
-			nil, sigHashes, currentInput.Value, prevOutFetcher,
-			cb,
-		)
+		keys = tweak.Compute(o.InternalKey, o.Data, tree)
+		o.OutputKey = keys.OutputKey
+
+		fmt.Fprintf(w, "output[%d] naked key: %x\n",
+			i, schnorr.SerializePubKey(keys.NakedKey))
+		if len(o.Data) != 0 {
+			fmt.Fprintf(w, "output[%d] data: %x\n", i, o.Data)
+		}
+		fmt.Fprintf(w, "output[%d] internal key: %x\n",
+			i, schnorr.SerializePubKey(keys.InternalKey))
+		if tree != nil {
+			fmt.Fprintf(w, "output[%d] taptree: %x\n", i, keys.TapRoot)
+
+			desc, standard, err := descriptor.Taproot(
+				keys.InternalKey, tree.RootNode,
+			)
+			if err != nil {
+				return nil, nil, err
+			}
+			fmt.Fprintf(w, "output[%d] %s: %s\n", i,
+				descriptorName(standard), desc)
+		}
 	}
 
-	var t *term.Term
-	var err error
-	if interactive {
-		// Set the terminal in raw mode, such that we can capture arrow
-		// presses.
-		t, err = term.Open("/dev/tty")
+	fmt.Fprintf(w, "output[%d] taproot key: %x:%d\n",
+		i, schnorr.SerializePubKey(o.OutputKey), o.Value)
+
+	addr, err := address.Taproot(o.OutputKey, chainParams)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprintf(w, "output[%d] address: %s\n", i, addr)
+
+	pkScript, err := txscript.PayToTaprootScript(o.OutputKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pkScript, keys, nil
+}
+
+// ExecuteTx executes the input at index txIdx of the given transaction step
+// by step. If trace is non-nil, the VM state at every step is written to it
+// as JSON, one step per line. If report is non-nil, a HTML report of the
+// complete execution is written to it before stepping starts.
+func ExecuteTx(tx *wire.MsgTx, prevOuts []*wire.TxOut, txIdx int,
+	interactive, noStep bool, tags map[string]string, skipAhead int,
+	trace, report io.Writer) error {
+
+	currentInput := prevOuts[txIdx]
+	setupFunc := TxSetup(tx, prevOuts, txIdx)
+
+	if report != nil {
+		err := writeReport(
+			setupFunc, tx.TxIn[txIdx], currentInput.PkScript, tags,
+			report,
+		)
 		if err != nil {
 			return err
 		}
-		defer t.Close()
+	}
 
-		term.RawMode(t)
-		defer t.Restore()
+	ui, err := newTerminal(interactive, noStep, tags, skipAhead)
+	if err != nil {
+		return err
 	}
+	defer ui.close()
 
-	currentStep := 1
-	prevLines := 0
-	bytes := make([]byte, 3)
+	observers := []Observer{ui}
 
-	// We'll start script execution and control the stepping by signalling
-	// on a channel.
-	stepChan := make(chan error, 1)
-	tableChan, errChan := StepScript(
-		setupFunc, stepChan, tx.TxIn[txIdx].Witness, tags, currentStep,
-	)
+	var tracer *Tracer
+	if trace != nil {
+		tracer = NewTracer(trace)
+		observers = append(observers, tracer)
+	}
 
 	for {
-		// Always start by signalling a step.
-		stepChan <- nil
-
-		var table string
-		var vmErr error
-		select {
-		case vmErr = <-errChan:
-		case table = <-tableChan:
+		vmErr := StepScript(
+			setupFunc, tx.TxIn[txIdx], currentInput.PkScript,
+			observers...,
+		)
+		if tracer != nil && tracer.Err() != nil {
+			return tracer.Err()
 		}
 
-		// Before handling any error, we draw the state table for the
-		// step.
-		clearLines := 0
-		if interactive {
-			clearLines = prevLines
-		}
+		switch {
+		// We are stepping backwards. Since we have not really
+		// optimized for this direction, we'll just start a new VM and
+		// have it execute up until the current step.
+		case ui.restart:
+			ui.restart = false
+			continue
 
-		if !noStep {
-			output.DrawTable(table, clearLines)
-		}
-		if interactive {
-			if currentStep > 1 {
-				fmt.Printf("Script execution: \u2190 back | next \u2192 ")
-			} else {
-				fmt.Printf("Script execution: next \u2192 ")
-			}
+		case ui.err != nil:
+			return ui.err
+
+		case ui.quit:
+			return fmt.Errorf("execution aborted")
 		}
 
-		// Take note of the number of lines just printed, such that we
-		// can clear them on next iteration in case we are using
-		// interactive mode.
-		prevLines = strings.Count(table, "\n") + 1
+		return vmErr
+	}
+}
 
-		// If the VM encountered no error, it means the script
-		// successfully executed to completion.
-		if table == "" && vmErr == nil {
-			output.ClearLines(1)
-			return nil
-		}
+// descriptorName returns how to label a descriptor. Descriptors with raw()
+// leaves can be read back by tapsim, but are rejected by wallets.
+func descriptorName(standard bool) string {
+	if standard {
+		return "descriptor"
+	}
 
-		// If we encountered an error other than errAbortVM,
-		// the script actually failed.
-		if table == "" && vmErr != errAbortVM {
-			output.ClearLines(1)
-			return vmErr
-		}
+	return "descriptor (non-standard, tapsim only)"
+}
 
-		// Otherwise script execution was aborted before it completed,
-		// so we continue with the next step of the execution.
+// SetupFunc creates a VM calling the given callback at every step.
+type SetupFunc func(func(*txscript.StepInfo) error) (*txscript.Engine, error)
 
-		if interactive && currentStep >= skipAhead {
-			skipAhead = 0
-			numRead, err := t.Read(bytes)
-			if err != nil {
-				return err
-			}
+// TxSetup returns a SetupFunc creating a VM executing the input at index
+// txIdx of the transaction, spending the given prevouts.
+func TxSetup(tx *wire.MsgTx, prevOuts []*wire.TxOut, txIdx int) SetupFunc {
+	prevOutFetcher := newPrevOutFetcher(tx, prevOuts)
+	currentInput := prevOuts[txIdx]
 
-			if numRead == 3 && bytes[0] == 27 && bytes[1] == 91 {
-				switch bytes[2] {
-				case 65:
-					//fmt.Print("Up arrow key pressed\r\n")
-				case 66:
-					//fmt.Print("Down arrow key pressed\r\n")
-				case 67:
-					//fmt.Print("Right arrow key pressed\r\n")
-					currentStep++
-				case 68:
-					//fmt.Print("Left arrow key pressed\r\n")
-					currentStep--
-					if currentStep < 1 {
-						currentStep = 1
-					}
-
-					// We are stepping backwards. Since we
-					// have not really optimized for this
-					// direction, we'll just start a new VM
-					// and have it execute up until the
-					// current step.
-					tableChan, errChan = StepScript(
-						setupFunc, stepChan,
-						tx.TxIn[txIdx].Witness, tags,
-						currentStep,
-					)
-				}
-
-			} else if numRead == 1 && bytes[0] == 3 {
-				// Ctrl+C pressed, quit the program
-				output.ClearLines(1)
-				return fmt.Errorf("execution aborted")
-			}
-		} else {
-			currentStep++
-		}
+	return func(cb func(*txscript.StepInfo) error) (*txscript.Engine, error) {
+		sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+		return txscript.NewDebugEngine(
+			currentInput.PkScript, tx, txIdx, scriptFlags,
+			nil, sigHashes, currentInput.Value, prevOutFetcher,
+			cb,
+		)
 	}
 }
 
-var errAbortVM = fmt.Errorf("aborting vm execution")
+// Step is the VM state at an execution step, as reported to observers by
+// StepScript.
+type Step struct {
+	// State is the VM state at this step, where the stacks are annotated
+	// with the changes since the previous step.
+	State output.StepState
+
+	// Script is the currently executing script, one opcode per element.
+	Script []string
 
-func StepScript(setupFunc func(func(*txscript.StepInfo) error) (*txscript.Engine, error),
-	stepChan <-chan error, witness [][]byte,
-	tags map[string]string, numSteps int) (<-chan string, <-chan error) {
+	// Witness is the witness stack shown at this step, if any.
+	Witness []string
+
+	// Verified is a message stating that the previous script was
+	// verified, set for scripts only reached after that.
+	Verified string
+
+	// Stack and AltStack are the stacks at this step, with the top
+	// element last.
+	Stack    [][]byte
+	AltStack [][]byte
+}
+
+// StepScript starts the VM created by setupFunc, and reports the state at
+// every step to the observers. Execution is paused while an observer handles
+// a step, and the commands returned by the observers control how execution
+// continues. When execution ends, the observers are notified, and the VM
+// error is returned. It is ErrAborted if an observer aborted execution.
+//
+// txIn and pkScript are the input being spent and its previous output
+// script, used to determine the role of each script the VM executes.
+func StepScript(setupFunc SetupFunc, txIn *wire.TxIn, pkScript []byte,
+	observers ...Observer) error {
 
 	var (
 		vm  *txscript.Engine
 		err error
 	)
 
-	const (
-		SCRIPT_SCRIPTSIG      = 0
-		SCRIPT_SCRIPTPUBKEY   = 1
-		SCRIPT_WITNESS_SCRIPT = 2
-	)
+	infos := scriptInfos(pkScript, txIn)
+	scriptInfo := func(idx int) scriptInfo {
+		if idx < len(infos) {
+			return infos[idx]
+		}
 
-	// We'll send outut for each step, or if we encounter an error, on
-	// these channels.
-	outputChan := make(chan string)
-	errChan := make(chan error, 1)
+		return scriptInfo{name: "unknown"}
+	}
+
+	// Observers returning CommandContinue are not notified of further
+	// steps.
+	active := make([]bool, len(observers))
+	for i := range active {
+		active[i] = true
+	}
+
+	done := func(vmErr error) error {
+		for _, o := range observers {
+			o.OnDone(vmErr)
+		}
+
+		return vmErr
+	}
 
 	// Set up a callback that we will use to inspect the engine state at
 	// every execution step.
 	var (
 		currentScript = -1
 		stepCounter   = 0
-		finalState    string
+
+		// We keep the stacks from the previous step, such that we can
+		// show what changed since then.
+		prevStack, prevAltStack [][]byte
+
+		// lastStep is the last state we got from the VM, used to
+		// describe the VM state in case execution fails.
+		lastStep *txscript.StepInfo
 	)
 	stepCallback := func(step *txscript.StepInfo) error {
-		finalState = ""
+		lastStep = step
 		var showWitness [][]byte
 
-		switch step.ScriptIndex {
-		// Script sig is empty and uninteresting under segwit, so we
-		// just ignore it.
-		case SCRIPT_SCRIPTSIG:
-			currentScript = step.ScriptIndex
-			return nil
-
-		// The scriptpubkey contains the witness program and is used to
-		// verify the script in the provided witness.
-		case SCRIPT_SCRIPTPUBKEY:
-			// Since to real script execution is done during the
-			// script pubkey (only checking the witness program),
-			// we will only output the step the first time we
-			// encounter this script index.
+		info := scriptInfo(step.ScriptIndex)
+
+		// A script only pushing the witness program is used to verify
+		// the script in the provided witness. Since no real script
+		// execution is done, we will only output the step the first
+		// time we encounter this script index.
+		if info.witnessProgram {
 			if currentScript == step.ScriptIndex {
 				return nil
 			}
 
-			showWitness = witness
-
-		// Execution of the witness script is the interesting part.
-		case SCRIPT_WITNESS_SCRIPT:
-			if currentScript <= step.ScriptIndex {
-				finalState += "witness program verified OK\n"
-			}
+			showWitness = txIn.Witness
 		}
 
 		stepCounter++
 		currentScript = step.ScriptIndex
 
-		// If we haven't reached the number of steps to execute, we'll
-		// return here to allow the VM to continue execution.
-		if stepCounter < numSteps {
-			return nil
-		}
+		stack := output.StackDiff(prevStack, step.Stack)
+		altStack := output.StackDiff(prevAltStack, step.AltStack)
+		prevStack = step.Stack
+		prevAltStack = step.AltStack
 
 		// Parse the current script for output.
 		scriptStr := output.VmScriptToString(vm, step.ScriptIndex)
-		table := output.ExecutionTable(
-			step.OpcodeIndex,
-			scriptStr,
-			output.StackToString(step.Stack),
-			output.StackToString(step.AltStack),
-			output.StackToString(showWitness),
-			tags,
-		)
 
-		finalState += table
-		finalState += "\n"
+		var opcode string
+		if step.OpcodeIndex < len(scriptStr) {
+			opcode = scriptStr[step.OpcodeIndex]
+		}
+
+		s := &Step{
+			State: output.StepState{
+				Step:        stepCounter,
+				ScriptIndex: step.ScriptIndex,
+				ScriptName:  info.name,
+				OpcodeIndex: step.OpcodeIndex,
+				Opcode:      opcode,
+				Stack:       stack,
+				AltStack:    altStack,
+			},
+			Script:   scriptStr,
+			Witness:  output.StackToString(showWitness),
+			Verified: info.verified,
+			Stack:    step.Stack,
+			AltStack: step.AltStack,
+		}
+
+		for i, o := range observers {
+			if !active[i] {
+				continue
+			}
 
-		// Now that we have executed enough steps, send the resulting
-		// output over the channel
-		outputChan <- finalState
+			switch o.OnStep(s) {
+			case CommandContinue:
+				active[i] = false
 
-		// Now wait for a signal to either continue execution or exit.
-		stepErr := <-stepChan
-		if stepErr != nil {
-			return stepErr
+			case CommandAbort:
+				return ErrAborted
+			}
 		}
 
 		return nil
@@ -418,16 +713,16 @@ func StepScript(setupFunc func(func(*txscript.StepInfo) error) (*txscript.Engine
 
 	vm, err = setupFunc(stepCallback)
 	if err != nil {
-		errChan <- err
-		return outputChan, errChan
+		return done(err)
 	}
 
-	// We wont' block on execution, but start the VM in a goroutine, such
-	// that the caller can step through it.
-	go func() {
-		vmErr := vm.Execute()
-		errChan <- vmErr
-	}()
+	vmErr := vm.Execute()
+	if vmErr != nil && vmErr != ErrAborted && lastStep != nil {
+		vmErr = newFailure(
+			vm, lastStep, scriptInfo(lastStep.ScriptIndex).name,
+			vmErr,
+		)
+	}
 
-	return outputChan, errChan
+	return done(vmErr)
 }
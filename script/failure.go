@@ -0,0 +1,355 @@
+package script
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/halseth/tapsim/output"
+)
+
+// Failure is returned when script execution fails. It wraps the error
+// returned by the VM, and describes the VM state just before the failure.
+type Failure struct {
+	// Err is the error returned from the VM.
+	Err error
+
+	// ScriptIndex is the index of the script that was executing when the
+	// VM failed.
+	ScriptIndex int
+
+	// ScriptName describes the role of the failing script, like
+	// scriptSig or witness script.
+	ScriptName string
+
+	// OpcodeIndex is the index of the failing opcode within the script.
+	// If the failure happened after the last opcode was executed, this
+	// will be equal to the number of opcodes in the script.
+	OpcodeIndex int
+
+	// Opcode is the failing opcode, or the empty string if execution
+	// failed after the script had completed.
+	Opcode string
+
+	// Stack and AltStack are the stacks just before the failure, with the
+	// top element last.
+	Stack    [][]byte
+	AltStack [][]byte
+
+	// ErrorCode is the txscript error code of Err, if HasErrorCode is
+	// set. Some errors, like those from OP_CHECKCONTRACTVERIFY, don't
+	// have an error code.
+	ErrorCode    txscript.ErrorCode
+	HasErrorCode bool
+
+	// Explanation is a plain-language explanation of the failure.
+	Explanation string
+
+	// Causes lists likely causes of the failure.
+	Causes []string
+}
+
+// Error returns the error message from the VM.
+func (f *Failure) Error() string {
+	return f.Err.Error()
+}
+
+// Unwrap returns the error from the VM.
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// String returns a multi-line, human-readable report of the failure.
+func (f *Failure) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "script execution failed: %s\n", f.Err)
+	if f.HasErrorCode {
+		fmt.Fprintf(&b, "  error code:  %s\n", f.ErrorCode)
+	}
+	fmt.Fprintf(&b, "  script:      %d (%s)\n", f.ScriptIndex,
+		f.ScriptName)
+
+	if f.Opcode != "" {
+		fmt.Fprintf(&b, "  opcode:      %d %s\n", f.OpcodeIndex, f.Opcode)
+	} else {
+		fmt.Fprintf(&b, "  opcode:      none (end of script)\n")
+	}
+
+	fmt.Fprintf(&b, "  stack before failure (top first):\n")
+	if len(f.Stack) == 0 {
+		fmt.Fprintf(&b, "    <empty>\n")
+	}
+	for i, s := range output.StackToString(f.Stack) {
+		fmt.Fprintf(&b, "    %d: %s\n", i, s)
+	}
+
+	if len(f.AltStack) > 0 {
+		fmt.Fprintf(&b, "  alt stack before failure (top first):\n")
+		for i, s := range output.StackToString(f.AltStack) {
+			fmt.Fprintf(&b, "    %d: %s\n", i, s)
+		}
+	}
+
+	if f.Explanation != "" {
+		fmt.Fprintf(&b, "  explanation: %s\n", f.Explanation)
+	}
+
+	if len(f.Causes) > 0 {
+		fmt.Fprintf(&b, "  likely causes:\n")
+		for _, c := range f.Causes {
+			fmt.Fprintf(&b, "    - %s\n", c)
+		}
+	}
+
+	return b.String()
+}
+
+// newFailure creates a Failure from the VM error and the last step info we
+// got from the VM before it failed.
+func newFailure(vm *txscript.Engine, last *txscript.StepInfo,
+	scriptName string, vmErr error) *Failure {
+
+	f := &Failure{
+		Err:         vmErr,
+		ScriptIndex: last.ScriptIndex,
+		ScriptName:  scriptName,
+		OpcodeIndex: last.OpcodeIndex,
+		Stack:       last.Stack,
+		AltStack:    last.AltStack,
+	}
+
+	scriptStr := output.VmScriptToString(vm, last.ScriptIndex)
+	if last.OpcodeIndex < len(scriptStr) {
+		f.Opcode = scriptStr[last.OpcodeIndex]
+	}
+
+	var scriptErr txscript.Error
+	if errors.As(vmErr, &scriptErr) {
+		f.ErrorCode = scriptErr.ErrorCode
+		f.HasErrorCode = true
+	}
+
+	f.explain()
+
+	return f
+}
+
+// explanation is a generic explanation of a txscript error code.
+type explanation struct {
+	summary string
+	causes  []string
+}
+
+var explanations = map[txscript.ErrorCode]explanation{
+	txscript.ErrEvalFalse: {
+		summary: "the script completed, but left a false value on top " +
+			"of the stack.",
+		causes: []string{
+			"a condition in the script evaluated to false",
+			"a wrong witness element was provided",
+		},
+	},
+	txscript.ErrEmptyStack: {
+		summary: "the script completed with an empty stack, but " +
+			"must leave a single true value.",
+		causes: []string{
+			"the script ends with a *VERIFY opcode, which leaves " +
+				"nothing on the stack",
+			"too few witness elements were provided",
+		},
+	},
+	txscript.ErrCleanStack: {
+		summary: "segwit scripts must leave exactly one element on the " +
+			"stack after execution.",
+		causes: []string{
+			"too many witness elements were provided",
+			"the script doesn't consume all the elements it pushes",
+		},
+	},
+	txscript.ErrVerify: {
+		summary: "OP_VERIFY found a false value on top of the stack.",
+		causes: []string{
+			"the condition preceding OP_VERIFY evaluated to false",
+		},
+	},
+	txscript.ErrEqualVerify: {
+		summary: "OP_EQUALVERIFY found the two top stack elements to " +
+			"be different.",
+		causes: []string{
+			"a wrong preimage or value was provided in the witness",
+			"witness elements were provided in the wrong order",
+		},
+	},
+	txscript.ErrNumEqualVerify: {
+		summary: "OP_NUMEQUALVERIFY found the two top stack elements " +
+			"to be numerically different.",
+		causes: []string{
+			"a wrong value was provided in the witness",
+			"an arithmetic operation gave an unexpected result",
+		},
+	},
+	txscript.ErrCheckSigVerify: {
+		summary: "OP_CHECKSIGVERIFY found the signature to be invalid.",
+		causes: []string{
+			"the signature was made with a different key",
+			"the signature commits to a different transaction or " +
+				"leaf script",
+			"signature and public key were provided in the wrong " +
+				"order",
+		},
+	},
+	txscript.ErrNullFail: {
+		summary: "a signature check failed with a non-empty signature.",
+		causes: []string{
+			"the signature was made with a different key",
+			"use an empty signature <> to make a signature check " +
+				"fail without failing the script",
+		},
+	},
+	txscript.ErrTaprootSigInvalid: {
+		summary: "a taproot signature was invalid for the given key " +
+			"and transaction.",
+		causes: []string{
+			"the signature was made with a different key",
+			"the signature commits to a different transaction or " +
+				"leaf script",
+		},
+	},
+	txscript.ErrInvalidStackOperation: {
+		summary: "an opcode tried to access more stack elements than " +
+			"available.",
+		causes: []string{
+			"too few witness elements were provided",
+			"an earlier opcode consumed elements expected here",
+		},
+	},
+	txscript.ErrUnbalancedConditional: {
+		summary: "OP_IF/OP_NOTIF/OP_ELSE/OP_ENDIF are not balanced.",
+		causes: []string{
+			"a missing OP_ENDIF",
+			"an OP_ELSE or OP_ENDIF without a matching OP_IF",
+		},
+	},
+	txscript.ErrMinimalIf: {
+		summary: "the argument to OP_IF/OP_NOTIF must be empty or " +
+			"exactly 01 in tapscript.",
+		causes: []string{
+			"use <> for false and 01 for true in the witness",
+		},
+	},
+	txscript.ErrMinimalData: {
+		summary: "a data push or number was not minimally encoded.",
+		causes: []string{
+			"numbers in the witness must use minimal CScriptNum " +
+				"encoding, e.g. <> for zero",
+		},
+	},
+	txscript.ErrNumberTooBig: {
+		summary: "a number on the stack was larger than allowed for " +
+			"arithmetic opcodes (4 bytes).",
+		causes: []string{
+			"a hash or key was used as a number",
+		},
+	},
+	txscript.ErrUnsatisfiedLockTime: {
+		summary: "the transaction doesn't satisfy the required lock " +
+			"time.",
+		causes: []string{
+			"the transaction locktime or input sequence is too low",
+		},
+	},
+	txscript.ErrDiscourageOpSuccess: {
+		summary: "the script contains an OP_SUCCESS opcode, which is " +
+			"non-standard.",
+		causes: []string{
+			"an unknown or disabled opcode was used in the script",
+		},
+	},
+	txscript.ErrTaprootMerkleProofInvalid: {
+		summary: "the control block doesn't prove the leaf script is " +
+			"committed to in the output key.",
+		causes: []string{
+			"the leaf script differs from the one in the taptree",
+			"the wrong internal key was used",
+			"the merkle path in the control block is wrong",
+		},
+	},
+	txscript.ErrWitnessProgramMismatch: {
+		summary: "the witness doesn't match the witness program in " +
+			"the output.",
+		causes: []string{
+			"the prevout doesn't belong to this input",
+		},
+	},
+	txscript.ErrElementTooBig: {
+		summary: "a stack element exceeded the maximum allowed size " +
+			"of 520 bytes.",
+		causes: []string{
+			"repeated OP_CAT produced too large an element",
+		},
+	},
+}
+
+// explain fills the Explanation and Causes of the failure, based on the error
+// code and the stack before the failure.
+func (f *Failure) explain() {
+	if exp, ok := explanations[f.ErrorCode]; ok && f.HasErrorCode {
+		f.Explanation = exp.summary
+		f.Causes = append(f.Causes, exp.causes...)
+	}
+
+	// Some errors are easier to understand by looking at the stack,
+	// which we add to the explanation.
+	top := func(i int) string {
+		if i >= len(f.Stack) {
+			return "<missing>"
+		}
+
+		b := f.Stack[len(f.Stack)-1-i]
+		if len(b) == 0 {
+			return "<>"
+		}
+
+		return hex.EncodeToString(b)
+	}
+
+	switch {
+	case f.HasErrorCode && (f.ErrorCode == txscript.ErrEqualVerify ||
+		f.ErrorCode == txscript.ErrNumEqualVerify):
+
+		f.Explanation += fmt.Sprintf(" Compared %s (top) with %s.",
+			top(0), top(1))
+
+	case f.HasErrorCode && f.ErrorCode == txscript.ErrCleanStack:
+		f.Explanation += fmt.Sprintf(" The stack has %d elements.",
+			len(f.Stack))
+
+	case f.HasErrorCode && f.ErrorCode == txscript.ErrEvalFalse:
+		f.Explanation += fmt.Sprintf(" The top element is %s.", top(0))
+
+	case strings.HasPrefix(f.Opcode, "OP_CHECKCONTRACTVERIFY"):
+		f.Explanation = "OP_CHECKCONTRACTVERIFY found the checked " +
+			"input or output doesn't commit to the expected key, " +
+			"data and taptree."
+		f.Causes = append(f.Causes,
+			"the embedded data differs from the expected",
+			"the taptree or internal key of the output differs",
+			"the checked input/output index is wrong",
+		)
+
+	case !f.HasErrorCode && f.Opcode == "":
+		// Errors from the deferred CCV amount checks happen at the
+		// end of execution.
+		if strings.Contains(f.Err.Error(), "output amt") {
+			f.Explanation = "the output amount is lower than the " +
+				"sum of the inputs checked against it by " +
+				"OP_CHECKCONTRACTVERIFY."
+			f.Causes = append(f.Causes,
+				"the output value doesn't include the input "+
+					"amounts",
+			)
+		}
+	}
+}
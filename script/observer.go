@@ -0,0 +1,118 @@
+package script
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ErrAborted is returned from StepScript when an observer aborted execution.
+var ErrAborted = errors.New("script execution aborted")
+
+// Command is returned by an observer to control how execution continues
+// after a step.
+type Command int
+
+const (
+	// CommandStep continues execution to the next step, which is
+	// reported to the observer.
+	CommandStep Command = iota
+
+	// CommandContinue runs execution to completion without reporting
+	// further steps to the observer. It is still notified when execution
+	// ends.
+	CommandContinue
+
+	// CommandAbort aborts execution, which then ends with ErrAborted.
+	CommandAbort
+)
+
+// String returns the name of the command.
+func (c Command) String() string {
+	switch c {
+	case CommandStep:
+		return "step"
+	case CommandContinue:
+		return "continue"
+	case CommandAbort:
+		return "abort"
+	default:
+		return "unknown"
+	}
+}
+
+// Observer is notified of the steps of a script execution by StepScript. This
+// is how frontends like the terminal table, the JSON tracer and the HTML
+// report follow the execution.
+type Observer interface {
+	// OnStep is called with the VM state at every step. Execution is
+	// paused until it returns, and the returned command controls how it
+	// continues.
+	OnStep(step *Step) Command
+
+	// OnDone is called when execution ends. The error is nil if the
+	// script executed successfully, ErrAborted if an observer aborted
+	// execution, or the error the VM failed with. If the VM failed during
+	// execution, it is a *Failure.
+	OnDone(err error)
+}
+
+// Recorder is an observer recording every step of the execution.
+type Recorder struct {
+	// Steps are the recorded steps.
+	Steps []*Step
+
+	// Err is the error execution ended with.
+	Err error
+}
+
+// OnStep records the step.
+func (r *Recorder) OnStep(step *Step) Command {
+	r.Steps = append(r.Steps, step)
+	return CommandStep
+}
+
+// OnDone records the error execution ended with.
+func (r *Recorder) OnDone(err error) {
+	r.Err = err
+}
+
+// Tracer is an observer writing the VM state at every step to a writer as
+// JSON, one step per line. Steps already written are skipped, such that the
+// same tracer can follow an execution that is restarted, like when stepping
+// backwards.
+type Tracer struct {
+	enc    *json.Encoder
+	traced int
+	err    error
+}
+
+// NewTracer returns a tracer writing to w.
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// OnStep writes the step, aborting execution if writing fails.
+func (t *Tracer) OnStep(step *Step) Command {
+	if step.State.Step <= t.traced {
+		return CommandStep
+	}
+
+	if err := t.enc.Encode(step.State); err != nil {
+		t.err = err
+		return CommandAbort
+	}
+	t.traced = step.State.Step
+
+	return CommandStep
+}
+
+// OnDone does nothing, as the trace only has the steps.
+func (t *Tracer) OnDone(error) {}
+
+// Err returns the error writing the trace failed with, if any.
+func (t *Tracer) Err() error {
+	return t.err
+}
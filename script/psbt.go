@@ -0,0 +1,212 @@
+package script
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TxFromPsbt extracts the transaction and prevouts from the PSBT, such that
+// they can be passed to ExecuteTx.
+//
+// The prevouts are taken from the witness_utxo fields, falling back to the
+// non_witness_utxo. The input witnesses are taken from final_scriptwitness
+// if set. Otherwise the witness is assembled from the first taproot leaf
+// script and its control block, with the available taproot script spend
+// signatures for that leaf as the witness elements.
+//
+// The returned tags map the x-only keys found in the taproot BIP32
+// derivation fields to their derivation paths.
+func TxFromPsbt(packet *psbt.Packet) (*wire.MsgTx, []*wire.TxOut,
+	map[string]string, error) {
+
+	tx := packet.UnsignedTx.Copy()
+	tags := make(map[string]string)
+
+	var prevOuts []*wire.TxOut
+	for i, pIn := range packet.Inputs {
+		txIn := tx.TxIn[i]
+
+		switch {
+		case pIn.WitnessUtxo != nil:
+			prevOuts = append(prevOuts, pIn.WitnessUtxo)
+
+		case pIn.NonWitnessUtxo != nil:
+			idx := txIn.PreviousOutPoint.Index
+			if int(idx) >= len(pIn.NonWitnessUtxo.TxOut) {
+				return nil, nil, nil, fmt.Errorf("input %d: "+
+					"non_witness_utxo has no output %d", i,
+					idx)
+			}
+			prevOuts = append(prevOuts, pIn.NonWitnessUtxo.TxOut[idx])
+
+		default:
+			return nil, nil, nil, fmt.Errorf("input %d: missing "+
+				"witness_utxo", i)
+		}
+
+		txIn.SignatureScript = pIn.FinalScriptSig
+
+		witness, err := psbtWitness(&pIn)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		txIn.Witness = witness
+
+		for _, d := range pIn.TaprootBip32Derivation {
+			tags[hex.EncodeToString(d.XOnlyPubKey)] = derivationPath(
+				d.MasterKeyFingerprint, d.Bip32Path,
+			)
+		}
+	}
+
+	for _, pOut := range packet.Outputs {
+		for _, d := range pOut.TaprootBip32Derivation {
+			tags[hex.EncodeToString(d.XOnlyPubKey)] = derivationPath(
+				d.MasterKeyFingerprint, d.Bip32Path,
+			)
+		}
+	}
+
+	return tx, prevOuts, tags, nil
+}
+
+// psbtWitness returns the witness for the PSBT input.
+func psbtWitness(pIn *psbt.PInput) (wire.TxWitness, error) {
+	if len(pIn.FinalScriptWitness) > 0 {
+		return parseWitness(pIn.FinalScriptWitness)
+	}
+
+	if len(pIn.TaprootLeafScript) > 0 {
+		return leafScriptWitness(pIn, pIn.TaprootLeafScript[0]), nil
+	}
+
+	if len(pIn.TaprootKeySpendSig) > 0 {
+		return wire.TxWitness{pIn.TaprootKeySpendSig}, nil
+	}
+
+	return nil, nil
+}
+
+// parseWitness deserializes a witness as found in the final_scriptwitness
+// field.
+func parseWitness(b []byte) (wire.TxWitness, error) {
+	r := bytes.NewReader(b)
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	witness := make(wire.TxWitness, 0, n)
+	for i := uint64(0); i < n; i++ {
+		el, err := wire.ReadVarBytes(
+			r, 0, txscript.MaxScriptSize, "witness element",
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		witness = append(witness, el)
+	}
+
+	return witness, nil
+}
+
+// leafScriptWitness assembles a witness spending the given leaf script.
+//
+// We don't know what witness the script expects, so we assume it checks
+// signatures for the keys in the order they appear in the script. That
+// means the signature for the first key must be on top of the stack, so we
+// add the signatures in reverse order. Keys without a signature get an empty
+// element.
+func leafScriptWitness(pIn *psbt.PInput,
+	leaf *psbt.TaprootTapLeafScript) wire.TxWitness {
+
+	leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+
+	sigs := make(map[string][]byte)
+	for _, s := range pIn.TaprootScriptSpendSig {
+		if !bytes.Equal(s.LeafHash, leafHash[:]) {
+			continue
+		}
+
+		sig := s.Signature
+		if s.SigHash != txscript.SigHashDefault {
+			sig = append(sig, byte(s.SigHash))
+		}
+		sigs[string(s.XOnlyPubKey)] = sig
+	}
+
+	var sigElements [][]byte
+	tokenizer := txscript.MakeScriptTokenizer(0, leaf.Script)
+	for tokenizer.Next() {
+		data := tokenizer.Data()
+		if len(data) != 32 {
+			continue
+		}
+
+		sig, ok := sigs[string(data)]
+		if !ok {
+			// Only add empty elements for keys we have
+			// derivation info for, other 32 byte pushes are
+			// likely not keys.
+			if !hasDerivation(pIn, data) {
+				continue
+			}
+			sig = []byte{}
+		}
+
+		sigElements = append(sigElements, sig)
+	}
+
+	var witness wire.TxWitness
+	for i := len(sigElements) - 1; i >= 0; i-- {
+		witness = append(witness, sigElements[i])
+	}
+
+	return append(witness, leaf.Script, leaf.ControlBlock)
+}
+
+// hasDerivation returns true if the input has taproot derivation info for
+// the given x-only key.
+func hasDerivation(pIn *psbt.PInput, key []byte) bool {
+	for _, d := range pIn.TaprootBip32Derivation {
+		if bytes.Equal(d.XOnlyPubKey, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// derivationPath formats the fingerprint and BIP32 path as
+// "fingerprint/path", with hardened indexes marked by an apostrophe.
+func derivationPath(fingerprint uint32, path []uint32) string {
+	// The fingerprint is serialized little endian in the PSBT, we want
+	// to show it as it is commonly shown in descriptors.
+	fp := []byte{
+		byte(fingerprint), byte(fingerprint >> 8),
+		byte(fingerprint >> 16), byte(fingerprint >> 24),
+	}
+
+	elements := []string{hex.EncodeToString(fp)}
+	for _, p := range path {
+		if p >= hdHardened {
+			elements = append(elements,
+				fmt.Sprintf("%d'", p-hdHardened))
+			continue
+		}
+
+		elements = append(elements, fmt.Sprintf("%d", p))
+	}
+
+	return strings.Join(elements, "/")
+}
+
+// hdHardened is the offset of hardened BIP32 child indexes.
+const hdHardened = 0x80000000
@@ -0,0 +1,40 @@
+package script
+
+import (
+	"io"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/halseth/tapsim/output"
+)
+
+// collectSteps runs the VM set up by setupFunc to completion, and returns
+// every step reported by StepScript together with the final VM error, if
+// any.
+func collectSteps(setupFunc SetupFunc, txIn *wire.TxIn, pkScript []byte) ([]*Step,
+	error) {
+
+	r := &Recorder{}
+	vmErr := StepScript(setupFunc, txIn, pkScript, r)
+
+	return r.Steps, vmErr
+}
+
+// writeReport executes the script non-interactively and writes a HTML report
+// of every step to w. A failing script is not an error here, it is shown in
+// the report instead.
+func writeReport(setupFunc SetupFunc, txIn *wire.TxIn, pkScript []byte, tags map[string]string,
+	w io.Writer) error {
+
+	steps, vmErr := collectSteps(setupFunc, txIn, pkScript)
+
+	var reportSteps []output.ReportStep
+	for _, step := range steps {
+		reportSteps = append(reportSteps, output.ReportStep{
+			StepState: step.State,
+			Script:    step.Script,
+			Witness:   step.Witness,
+		})
+	}
+
+	return output.WriteReport(w, reportSteps, tags, vmErr)
+}
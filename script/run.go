@@ -0,0 +1,205 @@
+package script
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/halseth/tapsim/output"
+	"github.com/halseth/tapsim/tweak"
+)
+
+// Options describe a script spend to run with Run or Execute. They correspond
+// to the arguments of BuildTx.
+type Options struct {
+	// Scripts are the scripts the spent output commits to. For taproot
+	// they are assembled into a taptree, unless TapTree is set.
+	Scripts [][]byte
+
+	// TapTree is the taptree of the spent output. If set, Scripts must be
+	// its leaf scripts in the order they are indexed.
+	TapTree *txscript.IndexedTapScriptTree
+
+	// ScriptIndex is the index of the script to execute.
+	ScriptIndex int
+
+	// ScriptType is the type of output committing to the script.
+	ScriptType ScriptType
+
+	// Witness is the witness used to spend the script, as returned by
+	// ParseWitness.
+	Witness []WitnessGen
+
+	// PrivKeys maps names of keys used for signatures in the witness to
+	// private keys. An empty key generates a random one.
+	PrivKeys map[string][]byte
+
+	// InputKey is the x-only internal key of the spent output, before
+	// embedding InputData. A random key is used if empty.
+	InputKey []byte
+
+	// InputData is the data embedded in the internal key of the spent
+	// output.
+	InputData []byte
+
+	// Input is the spent output. If the value is zero, 1e8 sats is used.
+	Input TxInput
+
+	// Outputs are the outputs of the transaction. A single output to a
+	// random key is used if empty.
+	Outputs []TxOutput
+
+	// ChainParams are the parameters of the network addresses are
+	// written for by Build. Mainnet is used if nil.
+	ChainParams *chaincfg.Params
+
+	// Tags maps hex values to human-readable tags, kept in the result.
+	Tags map[string]string
+}
+
+// Result is the result of running a script.
+type Result struct {
+	// Success is set if the script executed successfully.
+	Success bool
+
+	// Err is the error the script failed with. If the VM failed during
+	// execution, it is a *Failure describing the state at the failure.
+	Err error
+
+	// Steps is the VM state at every step of the execution.
+	Steps []output.StepState
+
+	// Stack and AltStack are the stacks after the last executed step, with
+	// the top element last. If the script failed, these are the stacks
+	// just before the failure.
+	Stack    [][]byte
+	AltStack [][]byte
+
+	// Tags maps hex values to human-readable tags, as given when running
+	// the script.
+	Tags map[string]string
+
+	// Tx is the spending transaction, and PrevOuts the outputs it spends.
+	Tx       *wire.MsgTx
+	PrevOuts []*wire.TxOut
+
+	// InputIndex is the index of the executed input.
+	InputIndex int
+
+	// InputKeys are the keys of the spent output, nil if it is not a
+	// taproot output. Only set by Run.
+	InputKeys *tweak.Keys
+
+	// OutputKeys are the keys of the outputs of the transaction, nil for
+	// outputs that are not taproot outputs. Only set by Run.
+	OutputKeys []*tweak.Keys
+}
+
+// Run builds a transaction spending the script like BuildTx, and executes it
+// to completion. Unlike Execute it does no terminal I/O, and returns the
+// result instead. A failing script is not an error, it is reported in the
+// result. An error is only returned if the script couldn't be executed.
+func Run(opts *Options) (*Result, error) {
+	b, err := opts.build(io.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := RunTx(b.tx, b.prevOuts, 0, opts.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	res.InputKeys = b.inputKeys
+	res.OutputKeys = b.outputKeys
+
+	return res, nil
+}
+
+// Build builds the transaction spending the script like BuildTx, writing the
+// keys and scripts it derives to w instead of stdout. It returns the
+// transaction together with the prevout it spends.
+func Build(opts *Options, w io.Writer) (*wire.MsgTx, []*wire.TxOut, error) {
+	b, err := opts.build(w)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b.tx, b.prevOuts, nil
+}
+
+// build checks the options and builds the transaction, writing the derived
+// keys to w.
+func (opts *Options) build(w io.Writer) (*builtTx, error) {
+	if len(opts.Scripts) == 0 {
+		return nil, fmt.Errorf("no scripts to run")
+	}
+
+	if opts.ScriptIndex < 0 || opts.ScriptIndex >= len(opts.Scripts) {
+		return nil, fmt.Errorf("script index %d out of range",
+			opts.ScriptIndex)
+	}
+
+	input := opts.Input
+	if input.Value == 0 {
+		input.Value = 1e8
+	}
+
+	return buildTx(
+		opts.PrivKeys, opts.InputKey, opts.InputData, input,
+		opts.Outputs, opts.Scripts, opts.TapTree, opts.ScriptIndex,
+		opts.ScriptType, opts.Witness, opts.ChainParams, w,
+	)
+}
+
+// RunTx executes the input at index txIdx of the given transaction to
+// completion, and returns the result like Run, with the given tags.
+func RunTx(tx *wire.MsgTx, prevOuts []*wire.TxOut, txIdx int,
+	tags map[string]string) (*Result, error) {
+
+	if len(prevOuts) != len(tx.TxIn) {
+		return nil, fmt.Errorf("got %d prevouts for %d inputs",
+			len(prevOuts), len(tx.TxIn))
+	}
+
+	if txIdx < 0 || txIdx >= len(tx.TxIn) {
+		return nil, fmt.Errorf("input index %d out of range", txIdx)
+	}
+
+	steps, vmErr := collectSteps(
+		TxSetup(tx, prevOuts, txIdx), tx.TxIn[txIdx],
+		prevOuts[txIdx].PkScript,
+	)
+
+	res := &Result{
+		Success:    vmErr == nil,
+		Err:        vmErr,
+		Tags:       tags,
+		Tx:         tx,
+		PrevOuts:   prevOuts,
+		InputIndex: txIdx,
+	}
+	for _, step := range steps {
+		res.Steps = append(res.Steps, step.State)
+	}
+
+	if len(steps) > 0 {
+		last := steps[len(steps)-1]
+		res.Stack = last.Stack
+		res.AltStack = last.AltStack
+	}
+
+	return res, nil
+}
+
+// Tag returns the tag of the hex encoded value, or the value itself if it has
+// no tag.
+func (r *Result) Tag(value string) string {
+	if tag, ok := r.Tags[value]; ok {
+		return tag
+	}
+
+	return value
+}
@@ -0,0 +1,94 @@
+package script_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/halseth/tapsim/script"
+	"github.com/halseth/tapsim/script/scripttest"
+)
+
+// TestRun checks the steps and stacks of a successful tapscript execution.
+func TestRun(t *testing.T) {
+	res := scripttest.Run(t, "OP_ADD OP_3 OP_EQUAL", "01 02")
+	scripttest.RequireSuccess(t, res)
+	scripttest.RequireStack(t, res, 2, "02", "01")
+	scripttest.RequireFinalStack(t, res, "01")
+
+	if res.InputKeys == nil {
+		t.Fatalf("expected input keys for a taproot spend")
+	}
+
+	if len(res.OutputKeys) != 1 {
+		t.Fatalf("expected 1 output, got %d", len(res.OutputKeys))
+	}
+
+	if res.Tx.TxOut[0].Value != res.PrevOuts[0].Value {
+		t.Fatalf("expected default output to spend the input value "+
+			"%d, got value %d", res.PrevOuts[0].Value,
+			res.Tx.TxOut[0].Value)
+	}
+}
+
+// TestRunFailure checks a failing script is reported in the result.
+func TestRunFailure(t *testing.T) {
+	res := scripttest.Run(t, "OP_ADD OP_4 OP_EQUAL", "01 02")
+	scripttest.RequireFailure(t, res, txscript.ErrEvalFalse)
+
+	var f *script.Failure
+	if !errors.As(res.Err, &f) {
+		t.Fatalf("expected a *script.Failure, got: %v", res.Err)
+	}
+
+	scripttest.RequireFinalStack(t, res, "<>")
+}
+
+// TestRunP2WSH checks the script can be committed to as a witness script.
+func TestRunP2WSH(t *testing.T) {
+	res := scripttest.Run(
+		t, "OP_ADD OP_3 OP_EQUAL", "01 02",
+		scripttest.WithScriptType(script.ScriptTypeP2WSH),
+	)
+	scripttest.RequireSuccess(t, res)
+	scripttest.RequireFinalStack(t, res, "01")
+
+	if res.InputKeys != nil {
+		t.Fatalf("expected no input keys for a P2WSH spend")
+	}
+}
+
+// TestRunTx checks running an input of an existing transaction gives the
+// same result as Run.
+func TestRunTx(t *testing.T) {
+	res := scripttest.Run(t, "OP_ADD OP_3 OP_EQUAL", "01 02")
+
+	txRes, err := script.RunTx(res.Tx, res.PrevOuts, 0, nil)
+	if err != nil {
+		t.Fatalf("unable to run tx: %v", err)
+	}
+	scripttest.RequireSuccess(t, txRes)
+
+	if len(txRes.Steps) != len(res.Steps) {
+		t.Fatalf("expected %d steps, got %d", len(res.Steps),
+			len(txRes.Steps))
+	}
+
+	if _, err := script.RunTx(res.Tx, res.PrevOuts, 1, nil); err == nil {
+		t.Fatalf("expected error for input index out of range")
+	}
+
+	if _, err := script.RunTx(res.Tx, nil, 0, nil); err == nil {
+		t.Fatalf("expected error for missing prevouts")
+	}
+}
+
+// TestRunTags checks tagged stack elements are given by their tag.
+func TestRunTags(t *testing.T) {
+	res := scripttest.Run(
+		t, "OP_ADD OP_3 OP_EQUAL", "01 02",
+		scripttest.WithTags(map[string]string{"02": "two"}),
+	)
+	scripttest.RequireSuccess(t, res)
+	scripttest.RequireStack(t, res, 2, "two", "01")
+}
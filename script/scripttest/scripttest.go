@@ -0,0 +1,197 @@
+// Package scripttest provides helpers for running scripts from Go tests and
+// asserting on the result, using the same string formats as tapsim.
+package scripttest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/halseth/tapsim/output"
+	"github.com/halseth/tapsim/script"
+)
+
+// Option modifies the options the script is run with.
+type Option func(*script.Options)
+
+// WithPrivKeys sets the private keys used for signatures in the witness.
+func WithPrivKeys(privKeys map[string][]byte) Option {
+	return func(o *script.Options) {
+		o.PrivKeys = privKeys
+	}
+}
+
+// WithInputKey sets the x-only internal key of the spent output, and the data
+// embedded in it.
+func WithInputKey(key, data []byte) Option {
+	return func(o *script.Options) {
+		o.InputKey = key
+		o.InputData = data
+	}
+}
+
+// WithOutputs sets the outputs of the spending transaction.
+func WithOutputs(outputs ...script.TxOutput) Option {
+	return func(o *script.Options) {
+		o.Outputs = outputs
+	}
+}
+
+// WithScriptType sets the type of output committing to the script.
+func WithScriptType(scriptType script.ScriptType) Option {
+	return func(o *script.Options) {
+		o.ScriptType = scriptType
+	}
+}
+
+// WithTags sets the tags of the result. Stack elements with a tag are given
+// by their tag instead of the hex value when asserting on the stack.
+func WithTags(tags map[string]string) Option {
+	return func(o *script.Options) {
+		o.Tags = tags
+	}
+}
+
+// Run parses the script and witness, and runs the script. Like the scripts
+// flag of tapsim, scriptStr can hold several scripts separated by newlines,
+// in which case the first is executed. The test fails if the script or
+// witness can't be parsed, or the script can't be run at all.
+func Run(t testing.TB, scriptStr, witness string,
+	opts ...Option) *script.Result {
+
+	t.Helper()
+
+	var scripts [][]byte
+	for _, s := range strings.Split(scriptStr, "\n") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+
+		parsed, err := script.Parse(s)
+		if err != nil {
+			t.Fatalf("unable to parse script %q: %v", s, err)
+		}
+
+		scripts = append(scripts, parsed)
+	}
+
+	witnessGen, err := script.ParseWitness(witness)
+	if err != nil {
+		t.Fatalf("unable to parse witness %q: %v", witness, err)
+	}
+
+	o := &script.Options{
+		Scripts: scripts,
+		Witness: witnessGen,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	res, err := script.Run(o)
+	if err != nil {
+		t.Fatalf("unable to run script: %v", err)
+	}
+
+	return res
+}
+
+// RequireSuccess fails the test if the script failed.
+func RequireSuccess(t testing.TB, res *script.Result) {
+	t.Helper()
+
+	if res.Success {
+		return
+	}
+
+	var f *script.Failure
+	if errors.As(res.Err, &f) {
+		t.Fatalf("%s", f)
+	}
+
+	t.Fatalf("script execution failed: %v", res.Err)
+}
+
+// RequireFailure fails the test if the script succeeded, or failed with an
+// error code other than the expected one.
+func RequireFailure(t testing.TB, res *script.Result,
+	code txscript.ErrorCode) {
+
+	t.Helper()
+
+	if res.Success {
+		t.Fatalf("expected script to fail with %v, but it succeeded",
+			code)
+	}
+
+	var scriptErr txscript.Error
+	if !errors.As(res.Err, &scriptErr) || scriptErr.ErrorCode != code {
+		t.Fatalf("expected script to fail with %v, got: %v", code,
+			res.Err)
+	}
+}
+
+// Stack returns the stack at the given step of the result, top element
+// first, in the format shown by tapsim: hex encoded, or <> if empty. Elements
+// with a tag are given by their tag. Steps are numbered from 1 like in the
+// execution trace, and include the steps verifying the witness program.
+func Stack(t testing.TB, res *script.Result, step int) []string {
+	t.Helper()
+
+	if step < 1 || step > len(res.Steps) {
+		t.Fatalf("step %d out of range, have %d steps", step,
+			len(res.Steps))
+	}
+
+	var stack []string
+	for _, e := range res.Steps[step-1].Stack {
+		if e.Diff == output.DiffPopped {
+			continue
+		}
+
+		stack = append(stack, res.Tag(e.Value))
+	}
+
+	return stack
+}
+
+// RequireStack fails the test if the stack at the given step differs from
+// the expected one, given top element first.
+func RequireStack(t testing.TB, res *script.Result, step int,
+	expected ...string) {
+
+	t.Helper()
+
+	requireEqual(t, "stack at step", step, Stack(t, res, step), expected)
+}
+
+// RequireFinalStack fails the test if the final stack differs from the
+// expected one, given top element first.
+func RequireFinalStack(t testing.TB, res *script.Result,
+	expected ...string) {
+
+	t.Helper()
+
+	var got []string
+	for _, e := range output.StackToString(res.Stack) {
+		got = append(got, res.Tag(e))
+	}
+
+	requireEqual(t, "final stack after step", len(res.Steps), got,
+		expected)
+}
+
+// requireEqual fails the test if the stacks differ.
+func requireEqual(t testing.TB, what string, step int, got,
+	expected []string) {
+
+	t.Helper()
+
+	if strings.Join(got, " ") == strings.Join(expected, " ") {
+		return
+	}
+
+	t.Fatalf("%s %d is [%s], expected [%s]", what, step,
+		strings.Join(got, " "), strings.Join(expected, " "))
+}
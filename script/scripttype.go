@@ -0,0 +1,203 @@
+package script
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ScriptType is the type of output the executed script is committed to.
+type ScriptType int
+
+const (
+	// ScriptTypeP2TR commits to the script as a tap leaf in a taproot
+	// output.
+	ScriptTypeP2TR ScriptType = iota
+
+	// ScriptTypeP2WSH commits to the script as a segwit v0 witness
+	// script.
+	ScriptTypeP2WSH
+
+	// ScriptTypeP2SH commits to the script as a P2SH redeem script.
+	ScriptTypeP2SH
+
+	// ScriptTypeP2SHP2WSH commits to the script as a P2WSH witness
+	// script nested in P2SH.
+	ScriptTypeP2SHP2WSH
+
+	// ScriptTypeLegacy uses the script directly as the output script.
+	ScriptTypeLegacy
+)
+
+// String returns the name of the script type, as accepted by
+// ParseScriptType.
+func (t ScriptType) String() string {
+	switch t {
+	case ScriptTypeP2TR:
+		return "p2tr"
+	case ScriptTypeP2WSH:
+		return "p2wsh"
+	case ScriptTypeP2SH:
+		return "p2sh"
+	case ScriptTypeP2SHP2WSH:
+		return "p2sh-p2wsh"
+	case ScriptTypeLegacy:
+		return "legacy"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// ParseScriptType parses the script type name. The empty string is parsed as
+// p2tr.
+func ParseScriptType(s string) (ScriptType, error) {
+	switch s {
+	case "", "p2tr":
+		return ScriptTypeP2TR, nil
+	case "p2wsh":
+		return ScriptTypeP2WSH, nil
+	case "p2sh":
+		return ScriptTypeP2SH, nil
+	case "p2sh-p2wsh":
+		return ScriptTypeP2SHP2WSH, nil
+	case "legacy":
+		return ScriptTypeLegacy, nil
+	default:
+		return 0, fmt.Errorf("unknown script type %q", s)
+	}
+}
+
+// p2wshScript returns the P2WSH output script committing to the given
+// witness script.
+func p2wshScript(witnessScript []byte) ([]byte, error) {
+	h := sha256.Sum256(witnessScript)
+	return txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).
+		AddData(h[:]).
+		Script()
+}
+
+// P2WPKHScript returns the P2WPKH output script paying to the given
+// compressed public key.
+func P2WPKHScript(pubKey []byte) ([]byte, error) {
+	return txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).
+		AddData(btcutil.Hash160(pubKey)).
+		Script()
+}
+
+// OpReturnScript returns the unspendable OP_RETURN output script carrying
+// the given data.
+func OpReturnScript(data []byte) ([]byte, error) {
+	return txscript.NewScriptBuilder().
+		AddOp(txscript.OP_RETURN).
+		AddData(data).
+		Script()
+}
+
+// p2shScript returns the P2SH output script committing to the given redeem
+// script.
+func p2shScript(redeemScript []byte) ([]byte, error) {
+	return txscript.NewScriptBuilder().
+		AddOp(txscript.OP_HASH160).
+		AddData(btcutil.Hash160(redeemScript)).
+		AddOp(txscript.OP_EQUAL).
+		Script()
+}
+
+// pushScript returns a script pushing all the given elements.
+func pushScript(elements ...[]byte) ([]byte, error) {
+	builder := txscript.NewScriptBuilder()
+	for _, e := range elements {
+		builder.AddData(e)
+	}
+
+	return builder.Script()
+}
+
+// scriptInfo describes the role of a script executed by the VM.
+type scriptInfo struct {
+	// name is a human-readable description of the script.
+	name string
+
+	// witnessProgram is set if the script only pushes a witness program,
+	// in which case there's no interesting execution to show.
+	witnessProgram bool
+
+	// verified is a message to show when executing this script, as it
+	// is only reached if the previous script verified OK.
+	verified string
+}
+
+// scriptInfos returns the scripts the VM will execute when spending the
+// given output script, indexed by the VM's script index.
+//
+// The VM will start with the scriptSig and the scriptPubKey. For P2SH the
+// redeem script will follow, and for segwit outputs the witness script. For
+// P2SH nested segwit, the redeem script is the witness program, followed by
+// the witness script.
+func scriptInfos(pkScript []byte, txIn *wire.TxIn) []scriptInfo {
+	infos := []scriptInfo{
+		{name: "scriptSig"},
+		{name: "scriptPubKey"},
+	}
+
+	switch {
+	case txscript.IsWitnessProgram(pkScript):
+		infos[1].witnessProgram = true
+		infos = append(infos, scriptInfo{
+			name:     witnessScriptName(pkScript),
+			verified: "witness program verified OK",
+		})
+
+	case txscript.IsPayToScriptHash(pkScript):
+		var redeemScript []byte
+		pushes, err := txscript.PushedData(txIn.SignatureScript)
+		if err == nil && len(pushes) > 0 {
+			redeemScript = pushes[len(pushes)-1]
+		}
+
+		redeem := scriptInfo{
+			name:     "redeem script",
+			verified: "script hash verified OK",
+		}
+
+		if !txscript.IsWitnessProgram(redeemScript) ||
+			len(txIn.Witness) == 0 {
+
+			infos = append(infos, redeem)
+			break
+		}
+
+		redeem.witnessProgram = true
+		infos = append(infos, redeem, scriptInfo{
+			name:     witnessScriptName(redeemScript),
+			verified: "witness program verified OK",
+		})
+	}
+
+	return infos
+}
+
+// ExecutedScriptIndex returns the index of the script committed to by the
+// output being spent, like the tapscript leaf, witness script or redeem
+// script. It is the last script executed by the VM.
+func ExecutedScriptIndex(pkScript []byte, txIn *wire.TxIn) int {
+	return len(scriptInfos(pkScript, txIn)) - 1
+}
+
+// witnessScriptName returns the name of the script executed when spending
+// the given witness program.
+func witnessScriptName(witnessProgram []byte) string {
+	switch {
+	case txscript.IsPayToTaproot(witnessProgram):
+		return "tapscript"
+	case txscript.IsPayToWitnessPubKeyHash(witnessProgram):
+		return "p2wpkh script"
+	default:
+		return "witness script"
+	}
+}
@@ -0,0 +1,180 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/halseth/tapsim/output"
+	"github.com/pkg/term"
+)
+
+// terminal is the observer drawing the execution table for every step. In
+// interactive mode it reads arrow key presses from the terminal to step
+// forwards and backwards.
+type terminal struct {
+	t           *term.Term
+	interactive bool
+	noStep      bool
+	tags        map[string]string
+	skipAhead   int
+
+	// currentStep is the step to show. Steps before it are executed
+	// without being drawn, which is used to step backwards by restarting
+	// execution.
+	currentStep int
+
+	// prevLines is the number of lines last printed, such that we can
+	// clear them before drawing the next step in interactive mode.
+	prevLines int
+
+	// restart is set when stepping backwards, in which case execution is
+	// aborted and must be restarted to reach currentStep.
+	restart bool
+
+	// quit is set if the user quit execution, or reading from the
+	// terminal failed with err.
+	quit bool
+	err  error
+}
+
+// newTerminal returns a terminal observer. In interactive mode the terminal
+// is set in raw mode until close is called.
+func newTerminal(interactive, noStep bool, tags map[string]string,
+	skipAhead int) (*terminal, error) {
+
+	ui := &terminal{
+		interactive: interactive,
+		noStep:      noStep,
+		tags:        tags,
+		skipAhead:   skipAhead,
+		currentStep: 1,
+	}
+
+	if !interactive {
+		return ui, nil
+	}
+
+	// Set the terminal in raw mode, such that we can capture arrow
+	// presses.
+	t, err := term.Open("/dev/tty")
+	if err != nil {
+		return nil, err
+	}
+
+	term.RawMode(t)
+	ui.t = t
+
+	return ui, nil
+}
+
+// close restores the terminal.
+func (ui *terminal) close() {
+	if ui.t == nil {
+		return
+	}
+
+	ui.t.Restore()
+	ui.t.Close()
+}
+
+// draw draws the table, clearing the previously drawn one in interactive
+// mode.
+func (ui *terminal) draw(table string) {
+	clearLines := 0
+	if ui.interactive {
+		clearLines = ui.prevLines
+	}
+
+	if !ui.noStep {
+		output.DrawTable(table, clearLines)
+	}
+	if ui.interactive {
+		if ui.currentStep > 1 {
+			fmt.Printf("Script execution: \u2190 back | next \u2192 ")
+		} else {
+			fmt.Printf("Script execution: next \u2192 ")
+		}
+	}
+
+	// Take note of the number of lines just printed, such that we can
+	// clear them on next iteration in case we are using interactive
+	// mode.
+	ui.prevLines = strings.Count(table, "\n") + 1
+}
+
+// OnStep draws the execution table for the step, and waits for a key press
+// in interactive mode.
+func (ui *terminal) OnStep(step *Step) Command {
+	if step.State.Step < ui.currentStep {
+		return CommandStep
+	}
+
+	var table string
+	if step.Verified != "" {
+		table += step.Verified + "\n"
+	}
+	table += output.ExecutionTable(
+		step.State.OpcodeIndex, step.Script, step.State.Stack,
+		step.State.AltStack, step.Witness, ui.tags,
+	)
+	table += "\n"
+
+	ui.draw(table)
+
+	if !ui.interactive || ui.currentStep < ui.skipAhead {
+		ui.currentStep++
+		return CommandStep
+	}
+
+	ui.skipAhead = 0
+	bytes := make([]byte, 3)
+	for {
+		numRead, err := ui.t.Read(bytes)
+		if err != nil {
+			ui.quit = true
+			ui.err = err
+			return CommandAbort
+		}
+
+		switch {
+		// Right arrow key pressed.
+		case numRead == 3 && bytes[0] == 27 && bytes[1] == 91 &&
+			bytes[2] == 67:
+
+			ui.currentStep++
+			return CommandStep
+
+		// Left arrow key pressed.
+		case numRead == 3 && bytes[0] == 27 && bytes[1] == 91 &&
+			bytes[2] == 68:
+
+			if ui.currentStep == 1 {
+				continue
+			}
+
+			ui.currentStep--
+			ui.restart = true
+			return CommandAbort
+
+		// Ctrl+C pressed, quit the program.
+		case numRead == 1 && bytes[0] == 3:
+			ui.quit = true
+			return CommandAbort
+		}
+	}
+}
+
+// OnDone clears the prompt when execution ends, unless it is restarted.
+func (ui *terminal) OnDone(err error) {
+	if ui.restart {
+		return
+	}
+
+	if ui.quit {
+		output.ClearLines(1)
+		return
+	}
+
+	ui.draw("")
+	output.ClearLines(1)
+}
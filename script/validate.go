@@ -0,0 +1,88 @@
+package script
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// InputResult is the result of validating a single transaction input.
+type InputResult struct {
+	// Index is the index of the input in the transaction.
+	Index int
+
+	// Err is the error returned from the VM, or nil if the input is
+	// valid.
+	Err error
+}
+
+// ValidateInputs executes the scripts of every input of the transaction
+// non-interactively, and returns the result for each input. An error is only
+// returned if the inputs couldn't be validated at all, script failures are
+// reported in the results as a *Failure if the VM failed during execution.
+func ValidateInputs(tx *wire.MsgTx, prevOuts []*wire.TxOut) ([]InputResult,
+	error) {
+
+	if len(prevOuts) != len(tx.TxIn) {
+		return nil, fmt.Errorf("got %d prevouts for %d inputs",
+			len(prevOuts), len(tx.TxIn))
+	}
+
+	for i, p := range prevOuts {
+		if p == nil {
+			return nil, fmt.Errorf("missing prevout for input %d", i)
+		}
+	}
+
+	prevOutFetcher := newPrevOutFetcher(tx, prevOuts)
+	sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+
+	var results []InputResult
+	for i, prevOut := range prevOuts {
+		// We keep the last step, such that we can describe the VM
+		// state in case execution fails.
+		var lastStep *txscript.StepInfo
+		vm, err := txscript.NewDebugEngine(
+			prevOut.PkScript, tx, i, scriptFlags, nil, sigHashes,
+			prevOut.Value, prevOutFetcher,
+			func(step *txscript.StepInfo) error {
+				lastStep = step
+				return nil
+			},
+		)
+		if err == nil {
+			err = vm.Execute()
+		}
+
+		if err != nil && lastStep != nil {
+			infos := scriptInfos(prevOut.PkScript, tx.TxIn[i])
+			name := "unknown"
+			if lastStep.ScriptIndex < len(infos) {
+				name = infos[lastStep.ScriptIndex].name
+			}
+
+			err = newFailure(vm, lastStep, name, err)
+		}
+
+		results = append(results, InputResult{
+			Index: i,
+			Err:   err,
+		})
+	}
+
+	return results, nil
+}
+
+// newPrevOutFetcher returns a fetcher for the given prevouts, which must be in
+// the same order as the transaction inputs.
+func newPrevOutFetcher(tx *wire.MsgTx,
+	prevOuts []*wire.TxOut) *txscript.MultiPrevOutFetcher {
+
+	prevMap := make(map[wire.OutPoint]*wire.TxOut)
+	for i, in := range tx.TxIn {
+		prevMap[in.PreviousOutPoint] = prevOuts[i]
+	}
+
+	return txscript.NewMultiPrevOutFetcher(prevMap)
+}
@@ -0,0 +1,90 @@
+package tweak
+
+import (
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// Keys are the keys of a taproot output committing to embedded data and a
+// taptree, the way OP_CHECKCONTRACTVERIFY checks them.
+type Keys struct {
+	// NakedKey is the internal key before embedding data.
+	NakedKey *btcec.PublicKey
+
+	// Data is the embedded data, empty if none.
+	Data []byte
+
+	// InternalKey is the naked key tweaked with the data, used as the
+	// taproot internal key. If no data is embedded, it is the naked key.
+	InternalKey *btcec.PublicKey
+
+	// TapRoot is the root hash of the taptree, empty if none.
+	TapRoot []byte
+
+	// OutputKey is the taproot output key, which is the internal key
+	// tweaked with the taptree. If there is no taptree, it is the
+	// internal key.
+	OutputKey *btcec.PublicKey
+}
+
+// Compute embeds the data in the naked key, and commits to the taptree. The
+// data and tree can be empty, in which case the respective tweak is not
+// applied.
+func Compute(nakedKey *btcec.PublicKey, data []byte,
+	tree *txscript.IndexedTapScriptTree) *Keys {
+
+	var tapRoot []byte
+	if tree != nil {
+		root := tree.RootNode.TapHash()
+		tapRoot = root[:]
+	}
+
+	return ComputeRoot(nakedKey, data, tapRoot)
+}
+
+// ComputeRoot embeds the data in the naked key, and commits to the taptree
+// with the given root hash.
+func ComputeRoot(nakedKey *btcec.PublicKey, data, tapRoot []byte) *Keys {
+	internalKey := nakedKey
+	if len(data) != 0 {
+		internalKey = txscript.SingleTweakPubKey(nakedKey, data)
+	}
+
+	outputKey := internalKey
+	if len(tapRoot) != 0 {
+		outputKey = txscript.ComputeTaprootOutputKey(
+			internalKey, tapRoot,
+		)
+	}
+
+	return &Keys{
+		NakedKey:    nakedKey,
+		Data:        data,
+		InternalKey: internalKey,
+		TapRoot:     tapRoot,
+		OutputKey:   outputKey,
+	}
+}
+
+// PkScript returns the taproot output script paying to the output key.
+func (k *Keys) PkScript() ([]byte, error) {
+	return txscript.PayToTaprootScript(k.OutputKey)
+}
+
+// ParseKey parses a hex encoded x-only key. The string "nums" and the empty
+// string are parsed as the BIP341 NUMS point, which has no known private key.
+func ParseKey(s string) (*btcec.PublicKey, error) {
+	keyBytes := txscript.BIP341_NUMS_POINT
+	if s != "" && s != "nums" {
+		var err error
+		keyBytes, err = hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return schnorr.ParsePubKey(keyBytes)
+}